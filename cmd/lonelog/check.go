@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alxark/lonelog/internal/app"
+	"github.com/hashicorp/hcl/v2"
+	"io"
+	"log"
+	"os"
+)
+
+// checkConfig - validate configuration and print all found problems, exit code is returned
+func checkConfig(confPath string) int {
+	files, diags := app.CheckConfig(confPath, log.New(io.Discard, "", 0))
+
+	writer := hcl.NewDiagnosticTextWriter(app.RedactWriter(os.Stderr), files, 0, false)
+	_ = writer.WriteDiagnostics(diags)
+
+	if diags.HasErrors() {
+		fmt.Fprintf(os.Stderr, "Configuration %s is invalid, %d problem(s) found\n", confPath, len(diags.Errs()))
+		return 1
+	}
+
+	fmt.Printf("Configuration %s is valid\n", confPath)
+
+	return 0
+}
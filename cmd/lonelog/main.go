@@ -6,7 +6,9 @@ import (
 	"github.com/alxark/lonelog/internal/app"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 )
 
 const (
@@ -14,47 +16,83 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "plugins":
+			printPlugins(os.Stdout, os.Args[2:])
+			return
+		case "test":
+			os.Exit(runTest(os.Args[2:]))
+		}
+	}
+
 	confPath := flag.String("config", "/etc/lonelog.conf", "path to configuration file")
+	confDir := flag.String("config-dir", "", "directory with *.hcl configuration files merged in name order, used instead of -config")
 	version := flag.Bool("version", false, "check version and exit")
+	check := flag.Bool("check", false, "validate configuration and exit")
 
 	// debugMode := flag.Bool("debug", false, "enable additional logging")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: lonelog [options]\n"+
+			"       lonelog plugins [plugin name...]\n"+
+			"       lonelog test [options] [input file]\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
+	if *confDir != "" {
+		confPath = confDir
+	}
+
+	// secrets of configuration are replaced in log
+	logger := log.New(app.RedactWriter(os.Stdout), "", log.LstdFlags|log.Lshortfile)
 
 	if *version {
 		fmt.Print("LoneLog Daemon\nCompiler: " + runtime.Compiler + "\nOS: " + runtime.GOOS + "\n")
 		return
 	}
 
-	logger.Println("Starting new application instance")
-
-	cfg, err := app.ReadConfig(*confPath)
-	if err != nil {
-		logger.Fatal("Configuration parsing error. Got: " + err.Error())
+	if *check {
+		os.Exit(checkConfig(*confPath))
 	}
 
-	var pipelines []*app.Pipeline
-	pipeline, err := app.NewPipeline(*cfg, *logger)
+	logger.Println("Starting new application instance")
+
+	supervisor, err := app.NewSupervisor(*confPath, logger)
 	if err != nil {
 		logger.Fatal(err)
 	}
-	pipelines = append(pipelines, &pipeline)
 
-	httpApi, err := app.NewHttp(*logger, pipelines)
+	httpApi, err := app.NewHttp(logger, supervisor)
 
-	for i := range pipelines {
-		logger.Printf("Starting pipeline #%d", i)
-		go pipelines[i].Run()
-	}
+	supervisor.Start()
 
 	var port int
-	if cfg.Global.HttpPort > 0 {
-		port = cfg.Global.HttpPort
+	if supervisor.Config.Global.HttpPort > 0 {
+		port = supervisor.Config.Global.HttpPort
 	} else {
 		port = defaultHttpPort
 	}
 	listen := fmt.Sprintf(":%d", port)
 	logger.Print("Starting HTTP API on " + listen)
-	httpApi.Serve(listen)
+	go httpApi.Serve(listen)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if err := supervisor.Reload(); err != nil {
+				logger.Printf("Configuration reload failed, keeping current configuration: %s", err.Error())
+			}
+			continue
+		}
+
+		logger.Printf("Received %s, stopping pipelines", sig)
+		break
+	}
+
+	supervisor.Stop()
+
+	logger.Println("Shutdown finished")
 }
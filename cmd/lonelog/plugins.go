@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"io"
+	"text/tabwriter"
+)
+
+/**
+ * Print registered plugins with documented options. When names are passed
+ * only matching plugins are printed
+ */
+func printPlugins(w io.Writer, names []string) {
+	filter := map[string]bool{}
+	for _, name := range names {
+		filter[name] = true
+	}
+
+	for _, kind := range []string{registry.KindInput, registry.KindFilter, registry.KindOutput} {
+		var plugins []registry.Plugin
+		for _, p := range registry.List(kind) {
+			if len(filter) == 0 || filter[p.Name] {
+				plugins = append(plugins, p)
+			}
+		}
+
+		if len(plugins) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "%ss:\n", kind)
+		for _, p := range plugins {
+			fmt.Fprintf(w, "  %s - %s\n", p.Name, p.Description)
+
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			for _, o := range p.Options {
+				details := o.Description
+				if o.Required {
+					details += " (required)"
+				} else if o.Default != "" {
+					details += " (default: " + o.Default + ")"
+				}
+
+				fmt.Fprintf(tw, "      %s\t%s\n", o.Name, details)
+			}
+
+			if p.Mapping != "" {
+				fmt.Fprintf(tw, "      *\t%s\n", p.Mapping)
+			}
+			tw.Flush()
+		}
+
+		fmt.Fprintln(w)
+	}
+}
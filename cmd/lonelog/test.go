@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/alxark/lonelog/internal/app"
+	"github.com/alxark/lonelog/internal/structs"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	testFormatLine    = "line"
+	testFormatPayload = "payload"
+	testFormatMessage = "message"
+
+	testMaxLineSize = 1 << 20
+)
+
+/**
+ * Run sample messages through filters of configured pipeline and print result
+ * payloads as JSON lines. When expected messages file is passed, result is compared
+ * with it and non-zero code is returned on difference. Messages are compared
+ * regardless of their order, tags are compared when expected file has messages
+ */
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	confPath := flags.String("config", "/etc/lonelog.conf", "path to configuration file")
+	confDir := flags.String("config-dir", "", "directory with *.hcl configuration files, used instead of -config")
+	pipelineName := flags.String("pipeline", "", "pipeline to test, required when there are several pipelines")
+	format := flags.String("format", testFormatLine, "input format: line - raw content, payload - JSON payload objects, message - JSON messages")
+	hostname := flags.String("hostname", "", "hostname of raw lines")
+	expectPath := flags.String("expect", "", "file with expected messages, one JSON object per line")
+	expectFormat := flags.String("expect-format", testFormatPayload, "expected messages format: payload - JSON payload objects, message - JSON messages with tags")
+	verbose := flags.Bool("verbose", false, "print filters log")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: lonelog test [options] [input file, stdin by default]\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *confDir != "" {
+		confPath = confDir
+	}
+
+	logger := log.New(io.Discard, "", log.LstdFlags|log.Lshortfile)
+	if *verbose {
+		logger.SetOutput(app.RedactWriter(os.Stderr))
+	}
+
+	config, err := app.ReadConfig(*confPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration parsing error: %s\n", err.Error())
+		return 2
+	}
+
+	pipeline, err := testPipeline(config, *pipelineName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	chain, err := app.NewFilterChain(pipeline, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize filters: %s\n", err.Error())
+		return 2
+	}
+
+	input := os.Stdin
+	if flags.NArg() > 0 && flags.Arg(0) != "-" {
+		input, err = os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 2
+		}
+		defer input.Close()
+	}
+
+	messages, err := readTestMessages(input, *format, *hostname)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	result, rejected := chain.Process(messages)
+
+	for _, msg := range result {
+		encoded, _ := json.Marshal(msg.Payload)
+		fmt.Println(string(encoded))
+	}
+
+	for _, deadLetter := range rejected {
+		encoded, _ := json.Marshal(deadLetter.Message.Payload)
+		fmt.Fprintf(os.Stderr, "rejected by %s: %s, payload: %s\n", deadLetter.Stage, deadLetter.Error, encoded)
+	}
+
+	if *expectPath == "" {
+		return 0
+	}
+
+	expected, err := readExpectedMessages(*expectPath, *expectFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	if !compareTestResult(os.Stderr, expected, result) {
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "OK, %d messages match expected messages\n", len(expected))
+
+	return 0
+}
+
+// testPipeline - pipeline by name, name could be omitted if there is only one pipeline
+func testPipeline(config *app.Configuration, name string) (app.PipelineConfiguration, error) {
+	if name == "" {
+		if len(config.Pipeline) > 1 {
+			return app.PipelineConfiguration{}, errors.New("there are several pipelines, choose one with -pipeline")
+		}
+
+		return config.Pipeline[0], nil
+	}
+
+	for _, pipeline := range config.Pipeline {
+		if pipeline.Name == name {
+			return pipeline, nil
+		}
+	}
+
+	return app.PipelineConfiguration{}, errors.New("pipeline not found: " + name)
+}
+
+func readTestMessages(input io.Reader, format string, hostname string) (messages []structs.Message, err error) {
+	switch format {
+	case testFormatLine, testFormatPayload, testFormatMessage:
+	default:
+		return nil, errors.New("unknown input format: " + format + ", should be line, payload or message")
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), testMaxLineSize)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber += 1 {
+		line := scanner.Text()
+		msg := structs.Message{AcceptTime: time.Now(), Hostname: hostname}
+
+		switch format {
+		case testFormatLine:
+			msg.Payload = map[string]string{"content": line}
+			if hostname != "" {
+				msg.Payload["hostname"] = hostname
+			}
+		case testFormatPayload:
+			if line == "" {
+				continue
+			}
+			err = json.Unmarshal([]byte(line), &msg.Payload)
+		case testFormatMessage:
+			if line == "" {
+				continue
+			}
+			err = json.Unmarshal([]byte(line), &msg)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("input line %d: %s", lineNumber, err.Error())
+		}
+
+		if msg.Payload == nil {
+			msg.Payload = map[string]string{}
+		}
+
+		messages = append(messages, msg)
+	}
+
+	return messages, scanner.Err()
+}
+
+// testExpectation - expected message, tags are compared only when expected file has messages
+type testExpectation struct {
+	payload   map[string]string
+	tags      []string
+	checkTags bool
+}
+
+func (e testExpectation) match(msg structs.Message) bool {
+	if !reflect.DeepEqual(e.payload, msg.Payload) {
+		return false
+	}
+
+	return !e.checkTags || equalTestTags(e.tags, msg.Tags)
+}
+
+// equalTestTags - compare tags regardless of order
+func equalTestTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	return reflect.DeepEqual(a, b)
+}
+
+func readExpectedMessages(path string, format string) (expected []testExpectation, err error) {
+	if format != testFormatPayload && format != testFormatMessage {
+		return nil, errors.New("unknown expected messages format: " + format + ", should be payload or message")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), testMaxLineSize)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber += 1 {
+		if scanner.Text() == "" {
+			continue
+		}
+
+		var e testExpectation
+		if format == testFormatMessage {
+			var msg structs.Message
+			err = json.Unmarshal(scanner.Bytes(), &msg)
+			e = testExpectation{payload: msg.Payload, tags: msg.Tags, checkTags: true}
+		} else {
+			err = json.Unmarshal(scanner.Bytes(), &e.payload)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineNumber, err.Error())
+		}
+
+		if e.payload == nil {
+			e.payload = map[string]string{}
+		}
+
+		expected = append(expected, e)
+	}
+
+	return expected, scanner.Err()
+}
+
+// compareTestResult - print expected messages which were not found and unexpected ones
+func compareTestResult(w io.Writer, expected []testExpectation, result []structs.Message) bool {
+	matched := true
+	used := make([]bool, len(result))
+
+expectedMessages:
+	for i, e := range expected {
+		for j, msg := range result {
+			if !used[j] && e.match(msg) {
+				used[j] = true
+				continue expectedMessages
+			}
+		}
+
+		matched = false
+		fmt.Fprintf(w, "expected message #%d not found: %s\n", i+1, formatTestMessage(e.payload, e.tags))
+	}
+
+	for j, msg := range result {
+		if !used[j] {
+			matched = false
+			fmt.Fprintf(w, "unexpected message #%d: %s\n", j+1, formatTestMessage(msg.Payload, msg.Tags))
+		}
+	}
+
+	return matched
+}
+
+func formatTestMessage(payload map[string]string, tags []string) string {
+	encoded, _ := json.Marshal(payload)
+	if len(tags) == 0 {
+		return string(encoded)
+	}
+
+	return fmt.Sprintf("%s, tags: %v", encoded, tags)
+}
@@ -10,7 +10,10 @@ require (
 	github.com/hashicorp/hcl/v2 v2.17.0
 	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/prometheus/client_golang v1.12.2
+	github.com/zclconf/go-cty v1.13.0
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 	gopkg.in/mcuadros/go-syslog.v2 v2.3.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -31,7 +34,6 @@ require (
 	github.com/prometheus/common v0.32.1 // indirect
 	github.com/prometheus/procfs v0.10.1 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
-	github.com/zclconf/go-cty v1.13.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
@@ -15,7 +15,7 @@ const (
 )
 
 type Benchmark struct {
-	log         log.Logger
+	log         *log.Logger
 	Channels    map[string]chan int
 	Counters    map[string]BenchmarkCounter
 	UpdateMutex sync.Mutex
@@ -32,7 +32,7 @@ type BenchmarkCounter struct {
 	NextRpsValue      int
 }
 
-func NewBenchmark(logger log.Logger) (bm *Benchmark, err error) {
+func NewBenchmark(logger *log.Logger) (bm *Benchmark, err error) {
 	bm = &Benchmark{}
 	bm.log = logger
 	bm.Channels = make(map[string]chan int)
@@ -50,7 +50,7 @@ func (bm *Benchmark) NewChannel(pluginName string) (chan int) {
 	}
 
 	if bm.isRunning {
-		bm.log.Fatal("trying to require new channel for %s while processing is already active", pluginName)
+		bm.log.Fatalf("trying to require new channel for %s while processing is already active", pluginName)
 	}
 
 	channel := make(chan int, defaultBenchmarkChannelSize)
@@ -0,0 +1,8 @@
+package app
+
+// built-in plugins register themselves in plugin registry on package initialization
+import (
+	_ "github.com/alxark/lonelog/internal/app/filters"
+	_ "github.com/alxark/lonelog/internal/app/inputs"
+	_ "github.com/alxark/lonelog/internal/app/outputs"
+)
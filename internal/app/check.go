@@ -0,0 +1,278 @@
+package app
+
+import (
+	"fmt"
+	"github.com/alxark/lonelog/internal/app/queues"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"log"
+	"os"
+	"sort"
+)
+
+/**
+ * CheckConfig validates configuration without starting pipelines. Every plugin is
+ * created and initialized, its options are checked against registered schema. All
+ * found problems are returned at once, parsed files are used to print them with
+ * source snippets
+ */
+func CheckConfig(filePath string, logger *log.Logger) (map[string]*hcl.File, hcl.Diagnostics) {
+	conf, files, diags := parseConfig(filePath)
+	if diags.HasErrors() {
+		return files, diags
+	}
+
+	for _, pipeline := range conf.Pipeline {
+		diags = append(diags, checkPipeline(pipeline, logger)...)
+	}
+
+	return files, diags
+}
+
+func checkPipeline(c PipelineConfiguration, logger *log.Logger) (diags hcl.Diagnostics) {
+	p := &Pipeline{Name: c.Name, log: logger}
+
+	diags = append(diags, checkQueue(c.Name, "input", c.In.queueOptions(), c.In.Body)...)
+	diags = append(diags, checkQueue(c.Name, "output", c.Out.queueOptions(), c.Out.Body)...)
+
+	for _, v := range c.In.Input {
+		diags = append(diags, checkPlugin(registry.KindInput, v.Name, v.Plugin, v.Options, v.Body, v.Factory != nil, func() error {
+			_, err := p.newInput(v)
+			return err
+		})...)
+	}
+
+	for i, v := range c.Filter {
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("Filter #%d", i)
+		}
+
+		if v.ServiceInterval == 0 {
+			v.ServiceInterval = defaultServiceInterval
+		}
+
+		if i < len(c.Filter)-1 {
+			diags = append(diags, checkQueue(c.Name, "filter-"+v.Name, v.queueOptions(), v.Body)...)
+		}
+
+		diags = append(diags, checkPlugin(registry.KindFilter, v.Name, v.Plugin, v.options(), v.Body, v.Factory != nil, func() error {
+			_, err := p.newFilter(v)
+			return err
+		})...)
+	}
+
+	for _, v := range c.Out.Output {
+		if _, err := p.addDelivery(v); err != nil {
+			diags = append(diags, pluginError(registry.KindOutput, v.Name, err, v.Body)...)
+		}
+
+		diags = append(diags, checkPlugin(registry.KindOutput, v.Name, v.Plugin, v.Options, v.Body, v.Factory != nil, func() error {
+			_, err := p.newOutput(v)
+			return err
+		})...)
+	}
+
+	if v := c.DeadLetter; v != nil {
+		if v.Mode != "" || v.Route != "" || len(v.OnlyTags) > 0 || len(v.SkipTags) > 0 {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unsupported dead letter output settings",
+				Detail:   "Mode, route and tags are not supported by dead letter output " + v.Name + ".",
+				Subject:  bodyRange(v.Body),
+			})
+		}
+
+		diags = append(diags, checkPlugin(registry.KindOutput, v.Name, v.Plugin, v.Options, v.Body, v.Factory != nil, func() error {
+			_, err := p.newOutput(*v)
+			return err
+		})...)
+	}
+
+	return diags
+}
+
+func checkQueue(pipeline string, name string, options queues.Options, body hcl.Body) hcl.Diagnostics {
+	options.Pipeline = pipeline
+	options.Name = name
+
+	if err := queues.Validate(options); err != nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid queue configuration",
+			Detail:   fmt.Sprintf("Queue %s: %s.", name, err.Error()),
+			Subject:  bodyRange(body),
+		}}
+	}
+
+	return nil
+}
+
+/**
+ * Check plugin options and create it. Plugin is not created when it's unknown,
+ * its errors are not reported when referenced files are missing, because they
+ * are caused by the same problem. Options decoding errors are reported anyway
+ */
+func checkPlugin(kind string, name string, plugin string, options registry.Options, body hcl.Body, custom bool, create func() error) hcl.Diagnostics {
+	diags := checkOptions(kind, plugin, options, body, custom)
+	if _, ok := registry.Lookup(kind, plugin); !ok && !custom {
+		return diags
+	}
+
+	if err := create(); err != nil {
+		if _, decoding := err.(hcl.Diagnostics); decoding || !diags.HasErrors() {
+			diags = append(diags, pluginError(kind, name, err, body)...)
+		}
+	}
+
+	return diags
+}
+
+/**
+ * Check plugin name and files referenced from options. Option keys and values are
+ * checked by plugin, when options are decoded. Plugins created by own factory have
+ * no schema and are not checked
+ */
+func checkOptions(kind string, plugin string, options registry.Options, body hcl.Body, custom bool) (diags hcl.Diagnostics) {
+	if custom {
+		return nil
+	}
+
+	info, ok := registry.Lookup(kind, plugin)
+	if !ok {
+		var names []string
+		for _, p := range registry.List(kind) {
+			names = append(names, p.Name)
+		}
+
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unknown " + kind + " plugin",
+			Detail:   fmt.Sprintf("There is no %s plugin %q.%s", kind, plugin, suggestion(plugin, names)),
+			Subject:  attributeRange(body, "", "plugin"),
+		}}
+	}
+
+	values := options.Values()
+	for _, o := range info.Options {
+		value, ok := values[o.Name]
+		if !ok || !o.File {
+			continue
+		}
+
+		if _, err := os.Stat(value); err != nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "File not found",
+				Detail:   fmt.Sprintf("Option %q should be path to existing file: %s.", o.Name, err.Error()),
+				Subject:  attributeRange(body, "options", o.Name),
+			})
+		}
+	}
+
+	return diags
+}
+
+// pluginError - options decoding errors are returned as is, they have own positions
+func pluginError(kind string, name string, err error, body hcl.Body) hcl.Diagnostics {
+	if diags, ok := err.(hcl.Diagnostics); ok {
+		// decoder reports options in random order
+		sort.SliceStable(diags, func(i, j int) bool {
+			return diags[i].Subject != nil && diags[j].Subject != nil && diags[i].Subject.Start.Byte < diags[j].Subject.Start.Byte
+		})
+
+		return diags
+	}
+
+	return hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid " + kind + " configuration",
+		Detail:   fmt.Sprintf("Failed to create %s %s: %s.", kind, name, err.Error()),
+		Subject:  bodyRange(body),
+	}}
+}
+
+// bodyRange - position of block, nil for blocks which are not read from file
+func bodyRange(body hcl.Body) *hcl.Range {
+	if body == nil {
+		return nil
+	}
+
+	return body.MissingItemRange().Ptr()
+}
+
+/**
+ * Position of attribute of block or of its nested block, position of block is
+ * used when attribute is not found
+ */
+func attributeRange(body hcl.Body, nested string, name string) *hcl.Range {
+	syntaxBody, ok := body.(*hclsyntax.Body)
+	if !ok {
+		return bodyRange(body)
+	}
+
+	if nested != "" {
+		for _, block := range syntaxBody.Blocks {
+			if block.Type == nested {
+				syntaxBody = block.Body
+				break
+			}
+		}
+	}
+
+	if attribute, ok := syntaxBody.Attributes[name]; ok {
+		return attribute.SrcRange.Ptr()
+	}
+
+	return syntaxBody.MissingItemRange().Ptr()
+}
+
+// suggestion - hint about the closest known name for misspelled one
+func suggestion(name string, known []string) string {
+	// names with more than a third of letters changed are not similar
+	best := ""
+	bestDistance := len(name)/3 + 2
+
+	for _, candidate := range known {
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" Did you mean %q?", best)
+}
+
+// editDistance - Levenshtein distance between strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i += 1 {
+		current[0] = i
+		for j := 1; j <= len(b); j += 1 {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
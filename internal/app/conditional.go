@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"github.com/alxark/lonelog/internal/app/expression"
+	"github.com/alxark/lonelog/internal/structs"
+)
+
+/**
+ * Conditional filter processes only messages selected by when expression and
+ * tags, others are passed to output as is. All messages are read by filter in
+ * order, skipped ones are written when filter reads them, so they keep order
+ * with processed messages
+ */
+type conditionalFilter struct {
+	structs.Filter
+
+	match func(msg structs.Message) bool
+}
+
+func (f *conditionalFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) error {
+	return f.Filter.Proceed(structs.WithSelector(ctx, f.match, output), input, output)
+}
+
+/**
+ * Wrap filter when it has when expression or tags selector, filter is returned
+ * as is when all messages are selected
+ */
+func newConditionalFilter(filter structs.Filter, condition *expression.Expression, tags tagSelector) structs.Filter {
+	if condition == nil && tags.empty() {
+		return filter
+	}
+
+	return &conditionalFilter{Filter: filter, match: func(msg structs.Message) bool {
+		if condition != nil && !condition.Match(msg) {
+			return false
+		}
+
+		return tags.Match(msg)
+	}}
+}
@@ -1,39 +1,522 @@
 package app
 
 import (
-	hcl "github.com/hashicorp/hcl/v2/hclsimple"
+	"errors"
+	"fmt"
+	"github.com/alxark/lonelog/internal/app/expression"
+	"github.com/alxark/lonelog/internal/app/queues"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+// name of pipeline described by top level in/filter/out blocks
+const defaultPipelineName = "default"
+
 type GlobalConfiguration struct {
-	OutputSplay  int `hcl:"output_splay"`
-	StatInterval int `hcl:"stat_interval"`
-	HttpPort     int `hcl:"http_port"`
+	OutputSplay     int `hcl:"output_splay"`
+	StatInterval    int `hcl:"stat_interval"`
+	HttpPort        int `hcl:"http_port"`
+	ShutdownTimeout int `hcl:"shutdown_timeout,optional"`
+	// directory for disk queues, every pipeline has own subdirectory
+	QueueDir          string `hcl:"queue_dir,optional"`
+	QueueSegmentBytes int64  `hcl:"queue_segment_bytes,optional"`
 }
 
 type InConfiguration struct {
-	Queue int           `hcl:"queue,optional"`
-	Input []InputPlugin `hcl:"input,block"`
+	Queue int `hcl:"queue,optional"`
+	// memory or disk
+	QueueType     string `hcl:"queue_type,optional"`
+	QueueMaxBytes int64  `hcl:"queue_max_bytes,optional"`
+	// block, drop_newest, drop_oldest or sample
+	Overflow       string        `hcl:"overflow,optional"`
+	OverflowSample int           `hcl:"overflow_sample,optional"`
+	Input          []InputPlugin `hcl:"input,block"`
+	// block body, used to report positions of configuration errors
+	Body hcl.Body `hcl:",body" json:"-"`
 }
 
 type OutConfiguration struct {
-	Queue  int            `hcl:"queue,optional"`
-	Output []OutputPlugin `hcl:"output,block"`
+	Queue          int            `hcl:"queue,optional"`
+	QueueType      string         `hcl:"queue_type,optional"`
+	QueueMaxBytes  int64          `hcl:"queue_max_bytes,optional"`
+	Overflow       string         `hcl:"overflow,optional"`
+	OverflowSample int            `hcl:"overflow_sample,optional"`
+	Output         []OutputPlugin `hcl:"output,block"`
+	Body           hcl.Body       `hcl:",body" json:"-"`
+}
+
+type PipelineConfiguration struct {
+	Name   string           `hcl:",label"`
+	In     InConfiguration  `hcl:"in,block"`
+	Out    OutConfiguration `hcl:"out,block"`
+	Filter []FilterPlugin   `hcl:"filter,block"`
+	// output for messages rejected by filters and outputs
+	DeadLetter *OutputPlugin `hcl:"dead_letter,block"`
+}
+
+/**
+ * ChainConfiguration is a named list of filters, which could be used by pipelines
+ * with filter block like `filter "parse" { chain = "nginx" }`
+ */
+type ChainConfiguration struct {
+	Name   string         `hcl:",label"`
+	Filter []FilterPlugin `hcl:"filter,block"`
+	Body   hcl.Body       `hcl:",body" json:"-"`
 }
 
 type Configuration struct {
-	Global GlobalConfiguration `hcl:"global,block"`
-	In     InConfiguration     `hcl:"in,block"`
-	Out    OutConfiguration    `hcl:"out,block"`
-	Filter []FilterPlugin      `hcl:"filter,block"`
+	Global     GlobalConfiguration     `hcl:"global,block"`
+	In         *InConfiguration        `hcl:"in,block"`
+	Out        *OutConfiguration       `hcl:"out,block"`
+	Filter     []FilterPlugin          `hcl:"filter,block"`
+	DeadLetter *OutputPlugin           `hcl:"dead_letter,block"`
+	Pipeline   []PipelineConfiguration `hcl:"pipeline,block"`
+	Chain      []ChainConfiguration    `hcl:"chain,block"`
 }
 
-func ReadConfig(filePath string) (*Configuration, error) {
+func (c InConfiguration) queueOptions() queues.Options {
+	return queues.Options{
+		Type:       c.QueueType,
+		Size:       c.Queue,
+		MaxBytes:   c.QueueMaxBytes,
+		Overflow:   c.Overflow,
+		SampleRate: c.OverflowSample,
+	}
+}
 
-	conf := &Configuration{}
+func (c OutConfiguration) queueOptions() queues.Options {
+	return queues.Options{
+		Type:       c.QueueType,
+		Size:       c.Queue,
+		MaxBytes:   c.QueueMaxBytes,
+		Overflow:   c.Overflow,
+		SampleRate: c.OverflowSample,
+	}
+}
 
-	if err := hcl.DecodeFile(filePath, nil, conf); err != nil {
-		return nil, err
+// ReadConfig - read configuration file or all *.hcl files of configuration directory
+func ReadConfig(configPath string) (*Configuration, error) {
+	conf, _, diags := parseConfig(configPath)
+	if diags.HasErrors() {
+		return nil, diags
 	}
 
 	return conf, nil
 }
+
+// schema of include attribute, it's allowed in every configuration file
+var includeSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{{Name: "include"}},
+}
+
+/**
+ * Parse and decode configuration. Path could be file or directory, files of directory
+ * are merged in order of their names. Included files are merged before including
+ * one, every file is read only once. Parsed files are returned with diagnostics, so
+ * errors could be printed with source snippets
+ */
+func parseConfig(configPath string) (conf *Configuration, files map[string]*hcl.File, diags hcl.Diagnostics) {
+	parser := hclparse.NewParser()
+	ctx := configContext()
+
+	paths, err := configFiles(configPath)
+	if err != nil {
+		return nil, parser.Files(), hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read configuration",
+			Detail:   err.Error(),
+		}}
+	}
+
+	var bodies []hcl.Body
+	read := make(map[string]bool)
+	for _, path := range paths {
+		diags = append(diags, parseConfigFile(parser, ctx, path, read, &bodies)...)
+	}
+
+	if diags.HasErrors() {
+		return nil, parser.Files(), diags
+	}
+
+	body := hcl.MergeBodies(bodies)
+
+	conf = &Configuration{}
+	diags = append(diags, gohcl.DecodeBody(body, ctx, conf)...)
+	if diags.HasErrors() {
+		return nil, parser.Files(), diags
+	}
+
+	if err := conf.normalizePipelines(); err != nil {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid pipelines configuration",
+			Detail:   err.Error(),
+			Subject:  body.MissingItemRange().Ptr(),
+		})
+		return nil, parser.Files(), diags
+	}
+
+	diags = append(diags, conf.expandChains()...)
+	if diags.HasErrors() {
+		return nil, parser.Files(), diags
+	}
+
+	// plugin options are decoded by plugins, they are evaluated with the same functions
+	for i := range conf.Pipeline {
+		conf.Pipeline[i].setContext(ctx)
+	}
+
+	return conf, parser.Files(), diags
+}
+
+func (c *PipelineConfiguration) setContext(ctx *hcl.EvalContext) {
+	for i := range c.In.Input {
+		c.In.Input[i].Options.Context = ctx
+	}
+
+	for i := range c.Filter {
+		if c.Filter[i].Options != nil {
+			c.Filter[i].Options.Context = ctx
+		}
+	}
+
+	for i := range c.Out.Output {
+		c.Out.Output[i].Options.Context = ctx
+	}
+
+	if c.DeadLetter != nil {
+		c.DeadLetter.Options.Context = ctx
+	}
+}
+
+// configFiles - configuration file or *.hcl files of directory sorted by name
+func configFiles(configPath string) ([]string, error) {
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{configPath}, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(configPath, "*.hcl"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("no *.hcl files in configuration directory " + configPath)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// parseConfigFile - parse file and files included by it, bodies are added in merge order
+func parseConfigFile(parser *hclparse.Parser, ctx *hcl.EvalContext, path string, read map[string]bool, bodies *[]hcl.Body) (diags hcl.Diagnostics) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	if read[absPath] {
+		return nil
+	}
+	read[absPath] = true
+
+	var file *hcl.File
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		file, diags = parser.ParseJSONFile(path)
+	} else {
+		file, diags = parser.ParseHCLFile(path)
+	}
+
+	if diags.HasErrors() {
+		return diags
+	}
+
+	content, remain, partialDiags := file.Body.PartialContent(includeSchema)
+	diags = append(diags, partialDiags...)
+
+	if attribute, ok := content.Attributes["include"]; ok {
+		var patterns []string
+		if d := gohcl.DecodeExpression(attribute.Expr, ctx, &patterns); d.HasErrors() {
+			return append(diags, d...)
+		}
+
+		for _, pattern := range patterns {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(path), pattern)
+			}
+
+			matches, err := filepath.Glob(pattern)
+			if err == nil && len(matches) == 0 {
+				err = errors.New("no files match " + pattern)
+			}
+
+			if err != nil {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Invalid include",
+					Detail:   err.Error() + ".",
+					Subject:  attribute.Expr.Range().Ptr(),
+				})
+				continue
+			}
+
+			for _, match := range matches {
+				diags = append(diags, parseConfigFile(parser, ctx, match, read, bodies)...)
+			}
+		}
+	}
+
+	*bodies = append(*bodies, remain)
+
+	return diags
+}
+
+/**
+ * Convert top level in/filter/out blocks to pipeline named "default" and
+ * check pipeline names
+ */
+func (c *Configuration) normalizePipelines() error {
+	if c.In != nil || c.Out != nil || len(c.Filter) > 0 || c.DeadLetter != nil {
+		if c.In == nil || c.Out == nil {
+			return errors.New("both in and out blocks are required for top level pipeline")
+		}
+
+		defaultPipeline := PipelineConfiguration{
+			Name:       defaultPipelineName,
+			In:         *c.In,
+			Out:        *c.Out,
+			Filter:     c.Filter,
+			DeadLetter: c.DeadLetter,
+		}
+		c.Pipeline = append([]PipelineConfiguration{defaultPipeline}, c.Pipeline...)
+	}
+
+	if len(c.Pipeline) == 0 {
+		return errors.New("no pipelines configured")
+	}
+
+	names := make(map[string]bool)
+	for _, pipeline := range c.Pipeline {
+		if pipeline.Name == "" {
+			return errors.New("pipeline name could not be empty")
+		}
+
+		if names[pipeline.Name] {
+			return errors.New("duplicate pipeline name: " + pipeline.Name)
+		}
+		names[pipeline.Name] = true
+	}
+
+	return nil
+}
+
+/**
+ * Replace filters referencing chains with filters of chains. Chain filters are
+ * named as "<referencing filter>.<chain filter>", so every usage has own metrics.
+ * Settings of referencing filter are applied to chain filters, see applyChainReference
+ */
+func (c *Configuration) expandChains() (diags hcl.Diagnostics) {
+	chains := make(map[string]ChainConfiguration)
+	for _, chain := range c.Chain {
+		if _, ok := chains[chain.Name]; ok {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate chain",
+				Detail:   "Chain " + chain.Name + " is already defined.",
+				Subject:  bodyRange(chain.Body),
+			})
+			continue
+		}
+
+		chains[chain.Name] = chain
+	}
+
+	for i := range c.Pipeline {
+		filters, d := expandFilters(c.Pipeline[i].Filter, chains, nil)
+		diags = append(diags, d...)
+		c.Pipeline[i].Filter = filters
+	}
+
+	return diags
+}
+
+// expandFilters - filters with chains replaced, stack contains chains which are being expanded
+func expandFilters(filters []FilterPlugin, chains map[string]ChainConfiguration, stack []string) (result []FilterPlugin, diags hcl.Diagnostics) {
+	for _, v := range filters {
+		if v.Chain == "" {
+			if v.Plugin == "" {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Missing filter plugin",
+					Detail:   "Filter " + v.Name + " should have plugin or chain.",
+					Subject:  bodyRange(v.Body),
+				})
+				continue
+			}
+
+			result = append(result, v)
+			continue
+		}
+
+		if v.Plugin != "" {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Conflicting filter settings",
+				Detail:   "Filter " + v.Name + " could have either plugin or chain.",
+				Subject:  attributeRange(v.Body, "", "chain"),
+			})
+			continue
+		}
+
+		chain, ok := chains[v.Chain]
+		if !ok {
+			var names []string
+			for name := range chains {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unknown chain",
+				Detail:   fmt.Sprintf("There is no chain %q.%s", v.Chain, suggestion(v.Chain, names)),
+				Subject:  attributeRange(v.Body, "", "chain"),
+			})
+			continue
+		}
+
+		if inStack(stack, v.Chain) {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Recursive chain",
+				Detail:   "Chain " + v.Chain + " is used by itself: " + strings.Join(append(stack, v.Chain), " -> ") + ".",
+				Subject:  attributeRange(v.Body, "", "chain"),
+			})
+			continue
+		}
+
+		expanded, d := expandFilters(chain.Filter, chains, append(stack, v.Chain))
+		diags = append(diags, d...)
+
+		expanded, d = applyChainReference(v, expanded)
+		diags = append(diags, d...)
+
+		result = append(result, expanded...)
+	}
+
+	return result, diags
+}
+
+/**
+ * Apply settings of filter referencing chain to chain filters:
+ *
+ *	when, only_tags, skip_tags - select messages for every filter of chain, they are
+ *	  combined with own settings of filters, so chain filters should not change
+ *	  fields and tags used by them
+ *	threads, service_interval, field - used by filters without own settings
+ *	debug - enables debug mode of all filters
+ *	queue settings - used for queue written by the last filter of chain
+ *
+ * Options and arguments are not supported, they belong to chain filters
+ */
+func applyChainReference(ref FilterPlugin, filters []FilterPlugin) (result []FilterPlugin, diags hcl.Diagnostics) {
+	if (ref.Options != nil && len(ref.Options.Values()) > 0) || len(ref.Args) > 0 {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported chain settings",
+			Detail:   "Filter " + ref.Name + " references chain " + ref.Chain + ", options and arguments should be set for chain filters.",
+			Subject:  bodyRange(ref.Body),
+		})
+	}
+
+	if ref.When != "" {
+		if _, err := expression.Compile(ref.When); err != nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid when expression",
+				Detail:   err.Error() + ".",
+				Subject:  attributeRange(ref.Body, "", "when"),
+			})
+		}
+	}
+
+	for i, filter := range filters {
+		filter.Name = ref.Name + "." + filter.Name
+
+		switch {
+		case ref.When == "":
+		case filter.When == "":
+			filter.When = ref.When
+		default:
+			filter.When = "(" + ref.When + ") && (" + filter.When + ")"
+		}
+
+		if len(ref.OnlyTags) > 0 {
+			if len(filter.OnlyTags) > 0 {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Conflicting chain settings",
+					Detail:   "Both filter " + ref.Name + " and chain filter " + filter.Name + " have only_tags, they could not be combined.",
+					Subject:  attributeRange(ref.Body, "", "only_tags"),
+				})
+			}
+			filter.OnlyTags = ref.OnlyTags
+		}
+		filter.SkipTags = append(append([]string{}, ref.SkipTags...), filter.SkipTags...)
+
+		if filter.Threads == 0 {
+			filter.Threads = ref.Threads
+		}
+
+		if filter.ServiceInterval == 0 {
+			filter.ServiceInterval = ref.ServiceInterval
+		}
+
+		if filter.Field == "" {
+			filter.Field = ref.Field
+		}
+
+		filter.Debug = filter.Debug || ref.Debug
+
+		if i == len(filters)-1 && ref.queueOptions() != (queues.Options{}) {
+			if filter.queueOptions() != (queues.Options{}) {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Conflicting chain settings",
+					Detail:   "Both filter " + ref.Name + " and the last chain filter " + filter.Name + " have queue settings.",
+					Subject:  bodyRange(ref.Body),
+				})
+			}
+
+			filter.Queue = ref.Queue
+			filter.QueueType = ref.QueueType
+			filter.QueueMaxBytes = ref.QueueMaxBytes
+			filter.Overflow = ref.Overflow
+			filter.OverflowSample = ref.OverflowSample
+		}
+
+		result = append(result, filter)
+	}
+
+	return result, diags
+}
+
+func inStack(stack []string, name string) bool {
+	for _, v := range stack {
+		if v == name {
+			return true
+		}
+	}
+
+	return false
+}
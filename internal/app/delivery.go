@@ -0,0 +1,222 @@
+package app
+
+import (
+	"errors"
+	"github.com/alxark/lonelog/internal/app/expression"
+	"github.com/alxark/lonelog/internal/app/queues"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+const (
+	outputModeBalance   = "balance"
+	outputModeBroadcast = "broadcast"
+)
+
+var unroutedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "pipeline",
+	Name:      "unrouted",
+	Help:      "Total number of messages dropped because no output route matched",
+}, []string{"pipeline"})
+
+var deliveryRegisterOnce = sync.Once{}
+
+/**
+ * Delivery is a queue read by outputs. Balance outputs share one delivery and
+ * compete for messages, every broadcast output has its own delivery and gets
+ * a copy of each message. Delivery with route or tags gets only matching messages
+ */
+type delivery struct {
+	name      string
+	broadcast bool
+	overflow  *queues.Overflow
+	size      int
+	route     string
+	condition *expression.Expression
+	tags      tagSelector
+	stream    chan structs.Message
+}
+
+/**
+ * Register delivery for output. Balance outputs with the same route and tags share
+ * the same delivery, it's named after the first of them when route or tags are set
+ */
+func (p *Pipeline) addDelivery(v OutputPlugin) (name string, err error) {
+	mode := v.Mode
+	if mode == "" {
+		mode = outputModeBalance
+	}
+
+	tags := newTagSelector(v.OnlyTags, v.SkipTags)
+
+	switch mode {
+	case outputModeBalance:
+		for _, d := range p.deliveries {
+			if !d.broadcast && d.route == v.Route && d.tags.equal(tags) {
+				return d.name, nil
+			}
+		}
+
+		name = outputModeBalance
+		if v.Route != "" || !tags.empty() {
+			name = v.Name
+		}
+	case outputModeBroadcast:
+		name = v.Name
+	default:
+		return "", errors.New("unknown mode for output " + v.Name + ": " + mode + ", should be balance or broadcast")
+	}
+
+	if p.getDelivery(name) != nil {
+		return "", errors.New("duplicate output delivery name: " + name)
+	}
+
+	size := defaultChannelSize
+	if v.Queue > 0 {
+		size = v.Queue
+	}
+
+	// slow broadcast output should not stall others, so it drops messages by default
+	policy := v.Overflow
+	if policy == "" && (mode == outputModeBalance || v.Block) {
+		policy = queues.OverflowBlock
+	} else if policy == "" {
+		policy = queues.OverflowDropNewest
+	}
+
+	overflow, err := queues.NewOverflow(policy, v.OverflowSample, p.Name, "output-"+name)
+	if err != nil {
+		return "", errors.New("invalid overflow for output " + v.Name + ": " + err.Error())
+	}
+
+	d := &delivery{
+		name:      name,
+		broadcast: mode == outputModeBroadcast,
+		overflow:  overflow,
+		size:      size,
+		route:     v.Route,
+		tags:      tags,
+	}
+
+	if v.Route != "" {
+		d.condition, err = expression.Compile(v.Route)
+		if err != nil {
+			return "", errors.New("invalid route for output " + v.Name + ": " + err.Error())
+		}
+	}
+
+	p.deliveries = append(p.deliveries, d)
+
+	return name, nil
+}
+
+func (p *Pipeline) getDelivery(name string) *delivery {
+	for _, d := range p.deliveries {
+		if d.name == name {
+			return d
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Create delivery queues. Single delivery reads output queue directly, so there
+ * is no additional overhead when all outputs are balanced
+ */
+func (p *Pipeline) setupDeliveries() {
+	if !p.isDispatched() {
+		p.deliveries[0].stream = p.OutputQueue.Out()
+		return
+	}
+
+	for _, d := range p.deliveries {
+		p.log.Printf("Creating delivery queue %s, size: %d, broadcast: %t, overflow: %s", d.name, d.size, d.broadcast, d.overflow.Policy)
+		d.stream = make(chan structs.Message, d.size)
+	}
+}
+
+// isDispatched - check if messages should be copied or routed from output queue to deliveries
+func (p *Pipeline) isDispatched() bool {
+	return len(p.deliveries) > 1 || (len(p.deliveries) == 1 && !p.deliveries[0].matchesAll())
+}
+
+// matchesAll - delivery has no route and tags, so it gets all messages
+func (d *delivery) matchesAll() bool {
+	return d.condition == nil && d.tags.empty()
+}
+
+func (d *delivery) match(msg structs.Message) bool {
+	if d.condition != nil && !d.condition.Match(msg) {
+		return false
+	}
+
+	return d.tags.Match(msg)
+}
+
+/**
+ * Copy messages from output queue to every matching delivery until output queue
+ * is closed. Deliveries with drop overflow policy drop messages when their queue
+ * is full, so slow output does not stall others. Messages matched by no route
+ * or tags are dropped
+ */
+func (p *Pipeline) dispatch() {
+	deliveryRegisterOnce.Do(func() {
+		prometheus.MustRegister(unroutedMetrics)
+	})
+
+	p.log.Printf("Dispatching messages to %d deliveries", len(p.deliveries))
+
+	matched := make([]*delivery, 0, len(p.deliveries))
+	for msg := range p.OutputQueue.Out() {
+		matched = matched[:0]
+		for _, d := range p.deliveries {
+			if d.match(msg) {
+				matched = append(matched, d)
+			}
+		}
+
+		if len(matched) == 0 {
+			unroutedMetrics.WithLabelValues(p.Name).Inc()
+			msg.Ack()
+			continue
+		}
+
+		if len(matched) > 1 && msg.AckFunc != nil {
+			msg.AckFunc = structs.ShareAck(msg.AckFunc, len(matched))
+		}
+
+		last := len(matched) - 1
+		for i, d := range matched {
+			deliveryMsg := msg
+			if i < last {
+				deliveryMsg = copyMessage(msg)
+			}
+
+			d.overflow.Push(d.stream, d.stream, deliveryMsg)
+		}
+	}
+
+	for _, d := range p.deliveries {
+		close(d.stream)
+	}
+
+	p.log.Printf("Dispatching finished")
+}
+
+// copyMessage - copy message with its payload, so outputs don't share payload map
+func copyMessage(msg structs.Message) structs.Message {
+	payload := make(map[string]string, len(msg.Payload))
+	for key, value := range msg.Payload {
+		payload[key] = value
+	}
+	msg.Payload = payload
+
+	if msg.Tags != nil {
+		msg.Tags = append([]string{}, msg.Tags...)
+	}
+
+	return msg
+}
@@ -0,0 +1,73 @@
+/**
+ * Small boolean expression language evaluated against messages. Used to route
+ * messages to outputs and to apply plugins conditionally.
+ *
+ * Examples:
+ *   level == "error" && hostname =~ "^web-"
+ *   status >= 500 or not exists(user_id)
+ *   "debug" in tags and method in ["GET", "HEAD"]
+ *
+ * Identifiers refer to payload fields, except "hostname" and "tags" which refer
+ * to message hostname and tags. Payload could be referenced explicitly with
+ * "payload.name" or payload["field with spaces"]
+ */
+package expression
+
+import (
+	"fmt"
+	"github.com/alxark/lonelog/internal/structs"
+)
+
+// Error - compilation error with position in expression source
+type Error struct {
+	Pos     int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos+1, e.Message)
+}
+
+func newError(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile - parse expression source, returns error with position of the problem
+func Compile(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// MustCompile - same as Compile, but panics on invalid expression
+func MustCompile(source string) *Expression {
+	e, err := Compile(source)
+	if err != nil {
+		panic("expression: Compile(" + source + "): " + err.Error())
+	}
+
+	return e
+}
+
+// Match - evaluate expression for message
+func (e *Expression) Match(msg structs.Message) bool {
+	return e.root.eval(&msg)
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
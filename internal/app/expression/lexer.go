@@ -0,0 +1,137 @@
+package expression
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators, longest first so "<=" is not split into "<" and "="
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+/**
+ * Split expression source to tokens. Identifiers could contain dots and dashes,
+ * so field names like "geoip.country" or "x-request-id" could be used as is
+ */
+func tokenize(source string) (tokens []token, err error) {
+	pos := 0
+
+	for pos < len(source) {
+		c := rune(source[pos])
+
+		if unicode.IsSpace(c) {
+			pos += 1
+			continue
+		}
+
+		if c == '"' || c == '\'' {
+			text, end, err := readString(source, pos)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: pos})
+			pos = end
+			continue
+		}
+
+		if isDigit(c) || (c == '-' && pos+1 < len(source) && isDigit(rune(source[pos+1]))) {
+			end := pos + 1
+			for end < len(source) && (isDigit(rune(source[end])) || source[end] == '.') {
+				end += 1
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: source[pos:end], pos: pos})
+			pos = end
+			continue
+		}
+
+		if isIdentStart(c) {
+			end := pos + 1
+			for end < len(source) && isIdentPart(rune(source[end])) {
+				end += 1
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, text: source[pos:end], pos: pos})
+			pos = end
+			continue
+		}
+
+		operator := ""
+		for _, op := range operators {
+			if strings.HasPrefix(source[pos:], op) {
+				operator = op
+				break
+			}
+		}
+
+		if operator == "" {
+			return nil, newError(pos, "unexpected character %q", c)
+		}
+
+		tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: pos})
+		pos += len(operator)
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(source)})
+
+	return tokens, nil
+}
+
+// readString - read quoted string starting at pos, returns unescaped value and position after closing quote
+func readString(source string, pos int) (value string, end int, err error) {
+	quote := source[pos]
+	var result strings.Builder
+
+	for i := pos + 1; i < len(source); i += 1 {
+		c := source[i]
+
+		if c == quote {
+			return result.String(), i + 1, nil
+		}
+
+		if c == '\\' && i+1 < len(source) {
+			i += 1
+			switch source[i] {
+			case 'n':
+				result.WriteByte('\n')
+			case 't':
+				result.WriteByte('\t')
+			default:
+				result.WriteByte(source[i])
+			}
+			continue
+		}
+
+		result.WriteByte(c)
+	}
+
+	return "", 0, newError(pos, "unterminated string")
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || c == '@' || unicode.IsLetter(c)
+}
+
+func isIdentPart(c rune) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == '-'
+}
@@ -0,0 +1,209 @@
+package expression
+
+import (
+	"github.com/alxark/lonelog/internal/structs"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	operandLiteral = iota
+	operandList
+	operandPayload
+	operandHostname
+	operandTags
+)
+
+type operand struct {
+	kind  int
+	text  string
+	items []string
+	pos   int
+}
+
+func fieldOperand(t token) operand {
+	switch {
+	case t.text == "hostname":
+		return operand{kind: operandHostname, text: t.text, pos: t.pos}
+	case t.text == "tags":
+		return operand{kind: operandTags, text: t.text, pos: t.pos}
+	case strings.HasPrefix(t.text, "payload."):
+		return operand{kind: operandPayload, text: strings.TrimPrefix(t.text, "payload."), pos: t.pos}
+	}
+
+	return operand{kind: operandPayload, text: t.text, pos: t.pos}
+}
+
+func (o operand) isList() bool {
+	return o.kind == operandList || o.kind == operandTags
+}
+
+// value - scalar operand value and flag if it's present in message
+func (o operand) value(msg *structs.Message) (string, bool) {
+	switch o.kind {
+	case operandLiteral:
+		return o.text, true
+	case operandHostname:
+		return msg.Hostname, msg.Hostname != ""
+	case operandPayload:
+		v, ok := msg.Payload[o.text]
+		return v, ok
+	}
+
+	return "", false
+}
+
+// list - list operand values
+func (o operand) list(msg *structs.Message) []string {
+	if o.kind == operandTags {
+		return msg.Tags
+	}
+
+	return o.items
+}
+
+type node interface {
+	eval(msg *structs.Message) bool
+}
+
+type constNode struct {
+	value bool
+}
+
+func (n *constNode) eval(msg *structs.Message) bool {
+	return n.value
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) eval(msg *structs.Message) bool {
+	return n.left.eval(msg) || n.right.eval(msg)
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) eval(msg *structs.Message) bool {
+	return n.left.eval(msg) && n.right.eval(msg)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(msg *structs.Message) bool {
+	return !n.operand.eval(msg)
+}
+
+// existsNode - field is present in message, even if it's empty
+type existsNode struct {
+	field operand
+}
+
+func (n *existsNode) eval(msg *structs.Message) bool {
+	if n.field.kind == operandTags {
+		return len(msg.Tags) > 0
+	}
+
+	_, ok := n.field.value(msg)
+	return ok
+}
+
+// truthyNode - field is present and not empty
+type truthyNode struct {
+	operand operand
+}
+
+func (n *truthyNode) eval(msg *structs.Message) bool {
+	if n.operand.kind == operandTags {
+		return len(msg.Tags) > 0
+	}
+
+	v, _ := n.operand.value(msg)
+	return v != ""
+}
+
+type inNode struct {
+	left, right operand
+}
+
+func (n *inNode) eval(msg *structs.Message) bool {
+	v, ok := n.left.value(msg)
+	if !ok {
+		return false
+	}
+
+	for _, item := range n.right.list(msg) {
+		if item == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Comparison of two scalar values. Ordering operators compare values as numbers
+ * and are false when any of values is missing or not a number
+ */
+type compareNode struct {
+	op          string
+	left, right operand
+
+	regexp   *regexp.Regexp
+	number   float64
+	constant bool
+}
+
+func (n *compareNode) eval(msg *structs.Message) bool {
+	left, ok := n.left.value(msg)
+
+	switch n.op {
+	case "=~":
+		return ok && n.regexp.MatchString(left)
+	case "!~":
+		return !ok || !n.regexp.MatchString(left)
+	}
+
+	right, _ := n.right.value(msg)
+
+	switch n.op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+
+	if !ok {
+		return false
+	}
+
+	a, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return false
+	}
+
+	b := n.number
+	if !n.constant {
+		if b, err = strconv.ParseFloat(right, 64); err != nil {
+			return false
+		}
+	}
+
+	switch n.op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+
+	return false
+}
@@ -0,0 +1,324 @@
+package expression
+
+import (
+	"regexp"
+	"strconv"
+)
+
+/**
+ * Recursive descent parser, precedence from lowest to highest:
+ * or, and, not, comparison
+ */
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) parse() (node, error) {
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, newError(t.pos, "unexpected %q", t.text)
+	}
+
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos += 1
+	}
+
+	return t
+}
+
+// accept - consume next token if it is one of operators or keywords
+func (p *parser) accept(values ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return false
+	}
+
+	for _, v := range values {
+		if t.text == v {
+			p.pos += 1
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) expect(value string) error {
+	if p.accept(value) {
+		return nil
+	}
+
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return newError(t.pos, "expected %q, got end of expression", value)
+	}
+
+	return newError(t.pos, "expected %q, got %q", value, t.text)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!", "not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &notNode{operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+
+		return inner, nil
+	}
+
+	t := p.peek()
+	if t.kind == tokenIdent && (t.text == "true" || t.text == "false") {
+		p.next()
+		return &constNode{value: t.text == "true"}, nil
+	}
+
+	if t.kind == tokenIdent && t.text == "exists" && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+
+		field, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		if field.kind == operandLiteral || field.kind == operandList {
+			return nil, newError(field.pos, "exists() expects field name")
+		}
+
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+
+		return &existsNode{field: field}, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	switch {
+	case op.kind == tokenOperator && isComparison(op.text):
+		p.next()
+		return p.parseComparison(op, left)
+	case op.kind == tokenIdent && op.text == "in":
+		p.next()
+		return p.parseIn(op, left, false)
+	case op.kind == tokenIdent && op.text == "not" && p.tokens[p.pos+1].text == "in":
+		p.pos += 2
+		return p.parseIn(op, left, true)
+	}
+
+	if left.kind == operandLiteral || left.kind == operandList {
+		return nil, newError(left.pos, "literal could not be used as condition")
+	}
+
+	return &truthyNode{operand: left}, nil
+}
+
+func (p *parser) parseComparison(op token, left operand) (node, error) {
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if left.isList() {
+		return nil, newError(left.pos, "%s is a list, use \"in\" operator", left.text)
+	}
+
+	if right.isList() {
+		return nil, newError(right.pos, "%s is a list, use \"in\" operator", right.text)
+	}
+
+	n := &compareNode{op: op.text, left: left, right: right}
+
+	switch op.text {
+	case "=~", "!~":
+		if right.kind != operandLiteral {
+			return nil, newError(right.pos, "regular expression should be a string")
+		}
+
+		n.regexp, err = regexp.Compile(right.text)
+		if err != nil {
+			return nil, newError(right.pos, "invalid regular expression: %s", err.Error())
+		}
+	case "<", "<=", ">", ">=":
+		if right.kind == operandLiteral {
+			n.number, err = strconv.ParseFloat(right.text, 64)
+			if err != nil {
+				return nil, newError(right.pos, "%q is not a number", right.text)
+			}
+			n.constant = true
+		}
+	}
+
+	return n, nil
+}
+
+func (p *parser) parseIn(op token, left operand, negate bool) (node, error) {
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if left.isList() {
+		return nil, newError(left.pos, "%s is a list, only single value could be searched", left.text)
+	}
+
+	if !right.isList() {
+		return nil, newError(right.pos, "\"in\" expects list or tags")
+	}
+
+	var n node = &inNode{left: left, right: right}
+	if negate {
+		n = &notNode{operand: n}
+	}
+
+	return n, nil
+}
+
+/**
+ * Operand is a field reference, string or number literal or list of literals.
+ * Payload fields with special characters are referenced as payload["name"]
+ */
+func (p *parser) parseOperand() (operand, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return operand{kind: operandLiteral, text: t.text, pos: t.pos}, nil
+	case tokenIdent:
+		if isKeyword(t.text) {
+			return operand{}, newError(t.pos, "unexpected %q", t.text)
+		}
+
+		if t.text == "payload" && p.accept("[") {
+			key := p.next()
+			if key.kind != tokenString {
+				return operand{}, newError(key.pos, "payload field name should be a string")
+			}
+
+			if err := p.expect("]"); err != nil {
+				return operand{}, err
+			}
+
+			return operand{kind: operandPayload, text: key.text, pos: t.pos}, nil
+		}
+
+		return fieldOperand(t), nil
+	case tokenOperator:
+		if t.text == "[" {
+			return p.parseList(t)
+		}
+	case tokenEOF:
+		return operand{}, newError(t.pos, "unexpected end of expression")
+	}
+
+	return operand{}, newError(t.pos, "unexpected %q", t.text)
+}
+
+func (p *parser) parseList(start token) (operand, error) {
+	list := operand{kind: operandList, text: "list", pos: start.pos}
+
+	for !p.accept("]") {
+		if len(list.items) > 0 {
+			if err := p.expect(","); err != nil {
+				return operand{}, err
+			}
+		}
+
+		t := p.next()
+		if t.kind != tokenString && t.kind != tokenNumber {
+			return operand{}, newError(t.pos, "list could contain only strings and numbers")
+		}
+
+		list.items = append(list.items, t.text)
+	}
+
+	return list, nil
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		return true
+	}
+
+	return false
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "and", "or", "not", "in", "true", "false":
+		return true
+	}
+
+	return false
+}
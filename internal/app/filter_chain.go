@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/alxark/lonelog/internal/structs"
+	"log"
+	"sync"
+)
+
+/**
+ * FilterChain runs messages through filters of pipeline without inputs, outputs
+ * and queues. It's used to check filters configuration on sample messages
+ */
+type FilterChain struct {
+	Name    string
+	filters []structs.Filter
+}
+
+func NewFilterChain(configuration PipelineConfiguration, logger *log.Logger) (c *FilterChain, err error) {
+	p := &Pipeline{Name: configuration.Name, log: logger}
+	c = &FilterChain{Name: configuration.Name}
+
+	for i, v := range configuration.Filter {
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("Filter #%d", i)
+		}
+
+		if v.ServiceInterval == 0 {
+			v.ServiceInterval = defaultServiceInterval
+		}
+
+		filterPlugin, err := p.newFilter(v)
+		if err != nil {
+			return nil, err
+		}
+
+		c.filters = append(c.filters, filterPlugin)
+	}
+
+	return c, nil
+}
+
+// Process - pass messages through all filters, result is in order of processing
+func (c *FilterChain) Process(messages []structs.Message) (result []structs.Message, rejected []structs.DeadLetter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deadLetters := make(chan structs.DeadLetter, defaultChannelSize)
+	collected := &sync.WaitGroup{}
+	collected.Add(1)
+	go func() {
+		defer collected.Done()
+		for deadLetter := range deadLetters {
+			rejected = append(rejected, deadLetter)
+		}
+	}()
+
+	stream := make(chan structs.Message, len(messages))
+	for _, msg := range messages {
+		stream <- msg
+	}
+	close(stream)
+
+	// every filter closes its output when input is drained, so the last stream
+	// is closed only when all filters are finished
+	for _, f := range c.filters {
+		f.SetDeadLetter(deadLetters)
+
+		output := make(chan structs.Message, defaultChannelSize)
+		go func(f structs.Filter, input chan structs.Message) {
+			defer close(output)
+			_ = f.Proceed(ctx, input, output)
+		}(f, stream)
+
+		stream = output
+	}
+
+	for msg := range stream {
+		result = append(result, msg)
+	}
+
+	close(deadLetters)
+	collected.Wait()
+
+	return result, rejected
+}
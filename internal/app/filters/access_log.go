@@ -0,0 +1,387 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	accessLogDefaultFormat   = "combined"
+	accessLogDefaultErrorTag = "access_log_error"
+	accessLogTimeLayout      = "02/Jan/2006:15:04:05 -0700"
+)
+
+// accessLogFormats - predefined formats, remote_ident is "-" in nginx logs and identd user in apache ones
+var accessLogFormats = map[string]string{
+	"common":   `$remote_addr $remote_ident $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+	"combined": `$remote_addr $remote_ident $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+}
+
+// AccessLogOptions - format is name of predefined format or nginx log_format string
+type AccessLogOptions struct {
+	Format   string `hcl:"format,optional"`
+	Prefix   string `hcl:"prefix,optional"`
+	ErrorTag string `hcl:"error_tag,optional"`
+}
+
+// accessLogToken - literal text of format or variable, variable is followed by literal or ends format
+type accessLogToken struct {
+	literal  string
+	variable string
+	// variable is enclosed in quotes, so it could contain escaped quotes and literal text
+	quoted bool
+}
+
+/**
+ * Access log filter parses web server logs without regular expressions. Format
+ * is compiled to sequence of literals and variables, every variable takes text
+ * up to the following literal. Fields are named after nginx variables, "-" values
+ * are skipped. Some variables produce typed fields:
+ *
+ *	$request - method, path, query and protocol, request is kept as is
+ *	$request_uri - path and query
+ *	$status - status, should be number
+ *	$body_bytes_sent - bytes, "-" is 0 like in apache common log
+ *	$request_time - request_time, should be number
+ *	$time_local - time in RFC3339
+ *
+ * Quoted values could contain quotes escaped as \" or \x22, escapes are decoded.
+ * Lines which don't match format are tagged
+ */
+type AccessLogFilter struct {
+	BasicFilter
+
+	format   string
+	tokens   []accessLogToken
+	prefix   string
+	errorTag string
+
+	log *log.Logger
+}
+
+func init() {
+	defaults := AccessLogOptions{Format: accessLogDefaultFormat, ErrorTag: accessLogDefaultErrorTag}
+
+	registry.RegisterFilter("access_log", registry.NewFilterFactory(defaults, NewAccessLogFilter), registry.Schema{
+		Description: "Parse web server access logs in common, combined or nginx log_format",
+		Options: []registry.Option{
+			{Name: "format", Description: "common, combined or nginx log_format string, ${var} is written as $${var} in HCL", Default: accessLogDefaultFormat},
+			{Name: "prefix", Description: "prefix of payload keys"},
+			{Name: "error_tag", Description: "tag of lines which don't match format, empty disables tagging", Default: accessLogDefaultErrorTag},
+		},
+	})
+}
+
+func NewAccessLogFilter(options AccessLogOptions, logger *log.Logger) (f *AccessLogFilter, err error) {
+	f = &AccessLogFilter{prefix: options.Prefix, errorTag: options.ErrorTag}
+	f.log = logger
+
+	f.format = options.Format
+	if predefined, ok := accessLogFormats[options.Format]; ok {
+		f.format = predefined
+	}
+
+	if f.tokens, err = compileAccessLogFormat(f.format); err != nil {
+		return nil, errors.New("invalid format: " + err.Error())
+	}
+
+	return f, nil
+}
+
+// compileAccessLogFormat - split format to literals and variables, both $name and ${name} are accepted
+func compileAccessLogFormat(format string) (tokens []accessLogToken, err error) {
+	var literal strings.Builder
+
+	for pos := 0; pos < len(format); {
+		if format[pos] != '$' {
+			literal.WriteByte(format[pos])
+			pos += 1
+			continue
+		}
+
+		name := ""
+		if strings.HasPrefix(format[pos:], "${") {
+			end := strings.IndexByte(format[pos:], '}')
+			if end < 0 {
+				return nil, errors.New("unterminated variable at position " + strconv.Itoa(pos))
+			}
+			name = format[pos+2 : pos+end]
+			pos += end + 1
+		} else {
+			end := pos + 1
+			for end < len(format) && isAccessLogVariableSymbol(format[end]) {
+				end += 1
+			}
+			name = format[pos+1 : end]
+			pos = end
+		}
+
+		if name == "" {
+			return nil, errors.New("empty variable name at position " + strconv.Itoa(pos))
+		}
+
+		if literal.Len() > 0 {
+			tokens = append(tokens, accessLogToken{literal: literal.String()})
+			literal.Reset()
+		} else if len(tokens) > 0 {
+			return nil, errors.New("variables " + tokens[len(tokens)-1].variable + " and " + name + " should be separated")
+		}
+
+		tokens = append(tokens, accessLogToken{variable: name})
+	}
+
+	if literal.Len() > 0 {
+		tokens = append(tokens, accessLogToken{literal: literal.String()})
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("empty format")
+	}
+
+	for i := range tokens {
+		if tokens[i].variable == "" || i == 0 || i == len(tokens)-1 {
+			continue
+		}
+
+		tokens[i].quoted = strings.HasSuffix(tokens[i-1].literal, `"`) && strings.HasPrefix(tokens[i+1].literal, `"`)
+	}
+
+	return tokens, nil
+}
+
+func isAccessLogVariableSymbol(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (f *AccessLogFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Access log filter started. Field: %s, format: %s", f.Field, f.format)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		line, ok := msg.Payload[f.Field]
+		if !ok {
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		fields, err := f.parse(line)
+		if err != nil {
+			if f.Debug {
+				f.log.Printf("Failed to parse line: %s", err.Error())
+			}
+
+			f.Malformed(&msg, f.errorTag)
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		for _, field := range fields {
+			msg.Payload[f.prefix+field[0]] = field[1]
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// parse - fields of line, text after the last token is ignored
+func (f *AccessLogFilter) parse(line string) (fields [][2]string, err error) {
+	line = strings.TrimRight(line, "\r\n")
+	pos := 0
+
+	for i, t := range f.tokens {
+		if t.variable == "" {
+			if !strings.HasPrefix(line[pos:], t.literal) {
+				return nil, errors.New("expected " + strconv.Quote(t.literal) + " at position " + strconv.Itoa(pos))
+			}
+			pos += len(t.literal)
+			continue
+		}
+
+		next := ""
+		if i+1 < len(f.tokens) {
+			next = f.tokens[i+1].literal
+		}
+
+		var end int
+		switch {
+		case t.quoted && i+2 == len(f.tokens) && strings.HasSuffix(line, next) && len(line)-len(next) >= pos:
+			// the last value takes the rest of line, so unescaped quotes inside it are kept
+			end = len(line) - len(next)
+		case t.quoted:
+			end = accessLogQuoteEnd(line, pos, next)
+		case next == "":
+			end = len(line)
+		default:
+			end = strings.Index(line[pos:], next)
+			if end >= 0 {
+				end += pos
+			}
+		}
+
+		if end < 0 {
+			return nil, errors.New("no value of " + t.variable + " at position " + strconv.Itoa(pos))
+		}
+
+		value := line[pos:end]
+		if t.quoted {
+			value = accessLogUnescape(value)
+		}
+		pos = end
+
+		if fields, err = appendAccessLogField(fields, t.variable, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return fields, nil
+}
+
+/**
+ * End of quoted value followed by literal. Quotes escaped with backslash are
+ * skipped, unescaped quotes inside value are tolerated when they are not
+ * followed by literal. Closing quote of value followed by single quote literal
+ * should end line or be followed by space
+ */
+func accessLogQuoteEnd(line string, pos int, next string) int {
+	for i := pos; i < len(line); i += 1 {
+		switch line[i] {
+		case '\\':
+			i += 1
+		case '"':
+			if !strings.HasPrefix(line[i:], next) {
+				continue
+			}
+
+			if len(next) > 1 || i+1 == len(line) || line[i+1] == ' ' {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// accessLogUnescape - decode \" and \\ of apache and printable \xHH of nginx
+func accessLogUnescape(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(value); i += 1 {
+		if value[i] != '\\' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		switch c := value[i+1]; {
+		case c == '"' || c == '\\':
+			result.WriteByte(c)
+			i += 1
+		case c == 'x' && i+3 < len(value):
+			// binary data like TLS handshake sent to plain HTTP port is kept escaped
+			if decoded, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil && decoded >= 0x20 && decoded < 0x7f {
+				result.WriteByte(byte(decoded))
+				i += 3
+				continue
+			}
+			result.WriteByte('\\')
+		default:
+			result.WriteByte('\\')
+		}
+	}
+
+	return result.String()
+}
+
+// appendAccessLogField - add fields of variable value, typed values are validated
+func appendAccessLogField(fields [][2]string, variable string, value string) ([][2]string, error) {
+	if value == "-" || value == "" {
+		if variable == "body_bytes_sent" {
+			return append(fields, [2]string{"bytes", "0"}), nil
+		}
+
+		return fields, nil
+	}
+
+	switch variable {
+	case "request":
+		fields = append(fields, [2]string{"request", value})
+		return appendAccessLogRequest(fields, value), nil
+	case "request_uri":
+		return appendAccessLogUri(fields, value), nil
+	case "status":
+		if _, err := strconv.Atoi(value); err != nil {
+			return nil, errors.New("invalid status " + strconv.Quote(value))
+		}
+		return append(fields, [2]string{"status", value}), nil
+	case "body_bytes_sent":
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, errors.New("invalid bytes " + strconv.Quote(value))
+		}
+		return append(fields, [2]string{"bytes", value}), nil
+	case "request_time":
+		requestTime, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New("invalid request_time " + strconv.Quote(value))
+		}
+		return append(fields, [2]string{"request_time", strconv.FormatFloat(requestTime, 'f', -1, 64)}), nil
+	case "time_local":
+		fields = append(fields, [2]string{"time_local", value})
+		if t, err := time.Parse(accessLogTimeLayout, value); err == nil {
+			fields = append(fields, [2]string{"time", t.Format(time.RFC3339)})
+		}
+		return fields, nil
+	}
+
+	return append(fields, [2]string{variable, value}), nil
+}
+
+// appendAccessLogRequest - split request line, garbage requests like TLS handshakes are kept only as request
+func appendAccessLogRequest(fields [][2]string, request string) [][2]string {
+	first := strings.IndexByte(request, ' ')
+	if first <= 0 || !isAccessLogMethod(request[:first]) {
+		return fields
+	}
+	fields = append(fields, [2]string{"method", request[:first]})
+
+	uri := request[first+1:]
+	if last := strings.LastIndexByte(uri, ' '); last >= 0 && strings.HasPrefix(uri[last+1:], "HTTP/") {
+		fields = append(fields, [2]string{"protocol", uri[last+1:]})
+		uri = uri[:last]
+	}
+
+	return appendAccessLogUri(fields, uri)
+}
+
+func appendAccessLogUri(fields [][2]string, uri string) [][2]string {
+	if path, query, found := strings.Cut(uri, "?"); found {
+		return append(fields, [2]string{"path", path}, [2]string{"query", query})
+	}
+
+	return append(fields, [2]string{"path", uri})
+}
+
+func isAccessLogMethod(method string) bool {
+	for i := 0; i < len(method); i += 1 {
+		if method[i] < 'A' || method[i] > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,16 +1,24 @@
 package filters
 
 import (
+	"context"
+	"errors"
 	"github.com/alxark/lonelog/internal/structs"
 	"github.com/prometheus/client_golang/prometheus"
+	"log"
 	"sync"
+	"time"
 )
 
 type BasicFilter struct {
 	Field           string
 	Name            string
+	Pipeline        string
 	Debug           bool
 	ServiceInterval int
+	DeadLetter      chan structs.DeadLetter
+	// Logger - pipeline logger, used when message is rejected without dead letter queue
+	Logger *log.Logger
 }
 
 var inputMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -18,14 +26,35 @@ var inputMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "filters",
 	Name:      "input",
 	Help:      "Total number of input messages",
-}, []string{"filter"})
+}, []string{"pipeline", "filter"})
 
 var outputMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Namespace: "ll",
 	Subsystem: "filters",
 	Name:      "output",
 	Help:      "Total number of output messages",
-}, []string{"filter"})
+}, []string{"pipeline", "filter"})
+
+var rejectedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "filters",
+	Name:      "rejected",
+	Help:      "Total number of messages sent to dead letter queue",
+}, []string{"pipeline", "filter"})
+
+var droppedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "filters",
+	Name:      "dropped",
+	Help:      "Total number of messages dropped intentionally",
+}, []string{"pipeline", "filter"})
+
+var malformedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "filters",
+	Name:      "malformed",
+	Help:      "Total number of messages which could not be parsed",
+}, []string{"pipeline", "filter"})
 
 var filterRegisterOnce = sync.Once{}
 
@@ -42,6 +71,9 @@ func (bf *BasicFilter) Init() error {
 	filterRegisterOnce.Do(func() {
 		prometheus.MustRegister(inputMetrics)
 		prometheus.MustRegister(outputMetrics)
+		prometheus.MustRegister(rejectedMetrics)
+		prometheus.MustRegister(droppedMetrics)
+		prometheus.MustRegister(malformedMetrics)
 	})
 
 	return nil
@@ -56,6 +88,18 @@ func (bf *BasicFilter) GetName() string {
 	return bf.Name
 }
 
+func (bf *BasicFilter) SetPipeline(pipelineName string) {
+	bf.Pipeline = pipelineName
+}
+
+func (bf *BasicFilter) SetDeadLetter(deadLetter chan structs.DeadLetter) {
+	bf.DeadLetter = deadLetter
+}
+
+func (bf *BasicFilter) SetLogger(logger *log.Logger) {
+	bf.Logger = logger
+}
+
 func (bf *BasicFilter) SetServiceInterval(interval int) {
 	bf.ServiceInterval = interval
 }
@@ -69,10 +113,38 @@ func (bf *BasicFilter) SetField(fieldName string) (err error) {
 	return
 }
 
-func (bf *BasicFilter) ReadMessage(input chan structs.Message) (msg structs.Message, ok bool) {
-	msg, ok = <-input
+/**
+ * ReadMessage - read next message, ok is false when input is closed or context is
+ * cancelled. Messages which are not selected for conditional filter are passed to
+ * output here, after all messages read before them
+ */
+func (bf *BasicFilter) ReadMessage(ctx context.Context, input chan structs.Message) (msg structs.Message, ok bool) {
+	for {
+		select {
+		case msg, ok = <-input:
+		case <-ctx.Done():
+			return msg, false
+		}
+
+		if !ok {
+			return
+		}
+
+		skipOutput, skipped := structs.Skipped(ctx, msg)
+		if !skipped {
+			break
+		}
+
+		select {
+		case skipOutput <- msg:
+			outputMetrics.WithLabelValues(bf.Pipeline, bf.GetName()).Inc()
+		case <-ctx.Done():
+			bf.Reject(msg, errors.New("filter is stopped before skipped message is passed to output"))
+			return msg, false
+		}
+	}
 
-	inputMetrics.WithLabelValues(bf.GetName()).Inc()
+	inputMetrics.WithLabelValues(bf.Pipeline, bf.GetName()).Inc()
 
 	return
 }
@@ -80,7 +152,43 @@ func (bf *BasicFilter) ReadMessage(input chan structs.Message) (msg structs.Mess
 func (bf *BasicFilter) WriteMessage(output chan structs.Message, msg structs.Message) error {
 	output <- msg
 
-	outputMetrics.WithLabelValues(bf.GetName()).Inc()
+	outputMetrics.WithLabelValues(bf.Pipeline, bf.GetName()).Inc()
 
 	return nil
 }
+
+// Drop - message is filtered out intentionally, it's acknowledged so persistent queue doesn't replay it
+func (bf *BasicFilter) Drop(msg structs.Message) {
+	droppedMetrics.WithLabelValues(bf.Pipeline, bf.GetName()).Inc()
+	msg.Ack()
+}
+
+/**
+ * Reject - send message which could not be processed to dead letter queue instead
+ * of output. Without dead letter queue message is logged and acknowledged
+ */
+func (bf *BasicFilter) Reject(msg structs.Message, err error) {
+	rejectedMetrics.WithLabelValues(bf.Pipeline, bf.GetName()).Inc()
+
+	if bf.DeadLetter == nil {
+		logger := bf.Logger
+		if logger == nil {
+			logger = log.Default()
+		}
+
+		logger.Printf("Filter %s rejected message: %s", bf.GetName(), err.Error())
+		msg.Ack()
+		return
+	}
+
+	bf.DeadLetter <- structs.DeadLetter{Message: msg, Stage: bf.GetName(), Error: err.Error(), Time: time.Now()}
+}
+
+// Malformed - count message which could not be parsed and mark it with tag, empty tag is not added
+func (bf *BasicFilter) Malformed(msg *structs.Message, tag string) {
+	malformedMetrics.WithLabelValues(bf.Pipeline, bf.GetName()).Inc()
+
+	if tag != "" {
+		msg.Tags = updateTags(msg.Tags, []string{tag}, nil)
+	}
+}
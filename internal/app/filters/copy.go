@@ -2,6 +2,7 @@ package filters
 
 import (
 	"context"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 )
@@ -11,10 +12,17 @@ type CopyFilter struct {
 
 	Mapping map[string]string
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewCopyFilter(options map[string]string, logger log.Logger) (s *CopyFilter, err error) {
+func init() {
+	registry.RegisterFilter("copy", registry.NewFilterFactory(map[string]string{}, NewCopyFilter), registry.Schema{
+		Description: "Copy field values to other fields",
+		Mapping:     "source field = target field",
+	})
+}
+
+func NewCopyFilter(options map[string]string, logger *log.Logger) (s *CopyFilter, err error) {
 	s = &CopyFilter{}
 
 	s.Mapping = options
@@ -29,7 +37,12 @@ func NewCopyFilter(options map[string]string, logger log.Logger) (s *CopyFilter,
 func (s *CopyFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
 	s.log.Printf("Set filter started. Total items: %d", len(s.Mapping))
 
-	for msg := range input {
+	for ctx.Err() == nil {
+		msg, ok := s.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		payload := msg.Payload
 
 		for fromField, toField := range s.Mapping {
@@ -41,7 +54,7 @@ func (s *CopyFilter) Proceed(ctx context.Context, input chan structs.Message, ou
 		}
 		msg.Payload = payload
 
-		output <- msg
+		_ = s.WriteMessage(output, msg)
 	}
 
 	s.log.Printf("Channel processing finished. Exiting")
@@ -0,0 +1,321 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	csvTypeString = "string"
+	csvTypeInt    = "int"
+	csvTypeFloat  = "float"
+	csvTypeBool   = "bool"
+
+	csvDefaultDelimiter = ","
+	csvDefaultQuote     = `"`
+	csvDefaultErrorTag  = "csv_error"
+)
+
+// CsvOptions - options of csv filter, parsed field is set by filter field attribute
+type CsvOptions struct {
+	Columns    cty.Value         `hcl:"columns,optional"`
+	Header     bool              `hcl:"header,optional"`
+	HeaderLine string            `hcl:"header_line,optional"`
+	Delimiter  string            `hcl:"delimiter,optional"`
+	Quote      string            `hcl:"quote,optional"`
+	Escape     string            `hcl:"escape,optional"`
+	Prefix     string            `hcl:"prefix,optional"`
+	Types      map[string]string `hcl:"types,optional"`
+	ErrorTag   string            `hcl:"error_tag,optional"`
+}
+
+/**
+ * Csv filter parses delimiter separated record to payload fields named after
+ * columns. Columns are configured with columns list or with header line of the
+ * source. Header detection means skipping of known header: records equal to the
+ * column names are dropped when header is set or header line is configured.
+ * Column names are never read from data, because filter could be started in the
+ * middle of the stream, and the first record it reads isn't always the header.
+ * Columns with empty name are skipped.
+ * Quoted values could contain delimiters, quote is escaped by doubling it or by
+ * escape symbol. Values of typed columns are normalized, like " 042" to "42" for
+ * int column. Records with wrong number of columns or invalid values are tagged
+ */
+type CsvFilter struct {
+	BasicFilter
+
+	header    bool
+	delimiter rune
+	quote     rune
+	escape    rune
+	prefix    string
+	types     map[string]string
+	errorTag  string
+	columns   []string
+
+	log *log.Logger
+}
+
+func init() {
+	defaults := CsvOptions{Delimiter: csvDefaultDelimiter, Quote: csvDefaultQuote, ErrorTag: csvDefaultErrorTag}
+
+	registry.RegisterFilter("csv", registry.NewFilterFactory(defaults, NewCsvFilter), registry.Schema{
+		Description: "Parse delimiter separated values from field to payload",
+		Options: []registry.Option{
+			{Name: "columns", Description: "list of column names, empty names are skipped"},
+			{Name: "header", Description: "drop header records, which are equal to configured column names, names are not read from data", Default: "false"},
+			{Name: "header_line", Description: "known header record of source, columns are read from it and records equal to it are dropped"},
+			{Name: "delimiter", Description: "column delimiter, \\t for tab", Default: csvDefaultDelimiter},
+			{Name: "quote", Description: "quote symbol", Default: csvDefaultQuote},
+			{Name: "escape", Description: "escape symbol, doubled quote is accepted anyway"},
+			{Name: "prefix", Description: "prefix of payload keys"},
+			{Name: "types", Description: "map of column types: string, int, float or bool"},
+			{Name: "error_tag", Description: "tag of records which could not be parsed, empty disables tagging", Default: csvDefaultErrorTag},
+		},
+	})
+}
+
+func NewCsvFilter(options CsvOptions, logger *log.Logger) (f *CsvFilter, err error) {
+	f = &CsvFilter{}
+
+	if f.columns, err = registry.StringList(options.Columns); err != nil {
+		return nil, errors.New("incorrect columns: " + err.Error())
+	}
+
+	if f.delimiter, err = csvSymbol(options.Delimiter, false); err != nil {
+		return nil, errors.New("incorrect delimiter: " + err.Error())
+	}
+
+	if f.quote, err = csvSymbol(options.Quote, true); err != nil {
+		return nil, errors.New("incorrect quote: " + err.Error())
+	}
+
+	if f.escape, err = csvSymbol(options.Escape, true); err != nil {
+		return nil, errors.New("incorrect escape: " + err.Error())
+	}
+
+	if f.delimiter == f.quote || f.delimiter == f.escape {
+		return nil, errors.New("delimiter should differ from quote and escape")
+	}
+
+	if f.escape != 0 && f.escape == f.quote {
+		return nil, errors.New("escape should differ from quote, doubled quote is accepted anyway")
+	}
+
+	if options.HeaderLine != "" {
+		if len(f.columns) > 0 {
+			return nil, errors.New("only one of columns and header_line should be set")
+		}
+
+		if f.columns, err = f.parse(options.HeaderLine); err != nil {
+			return nil, errors.New("incorrect header_line: " + err.Error())
+		}
+
+		options.Header = true
+	}
+
+	if len(f.columns) == 0 {
+		return nil, errors.New("no columns and header_line is not set")
+	}
+
+	for column, columnType := range options.Types {
+		switch columnType {
+		case csvTypeString, csvTypeInt, csvTypeFloat, csvTypeBool:
+		default:
+			return nil, errors.New("unknown type of column " + column + ": " + columnType + ", should be string, int, float or bool")
+		}
+	}
+
+	f.header = options.Header
+	f.prefix = options.Prefix
+	f.types = options.Types
+	f.errorTag = options.ErrorTag
+	f.log = logger
+
+	return f, nil
+}
+
+// csvSymbol - single symbol option, \t is accepted for tab
+func csvSymbol(value string, optional bool) (rune, error) {
+	if value == `\t` {
+		return '\t', nil
+	}
+
+	if value == "" && optional {
+		return 0, nil
+	}
+
+	symbol, size := utf8.DecodeRuneInString(value)
+	if size == 0 || size != len(value) {
+		return 0, errors.New("single symbol expected, got " + strconv.Quote(value))
+	}
+
+	return symbol, nil
+}
+
+func (f *CsvFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Csv filter started. Field: %s, delimiter: %q, header: %t, columns: %v", f.Field, f.delimiter, f.header, f.columns)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		source, ok := msg.Payload[f.Field]
+		if !ok {
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		values, err := f.parse(source)
+		if err == nil && f.header && f.isHeader(values) {
+			f.Drop(msg)
+			continue
+		}
+
+		if err == nil {
+			err = f.store(msg.Payload, values)
+		}
+
+		if err != nil {
+			if f.Debug {
+				f.log.Printf("Failed to parse record: %s", err.Error())
+			}
+
+			f.Malformed(&msg, f.errorTag)
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// isHeader - check if record repeats column names
+func (f *CsvFilter) isHeader(values []string) bool {
+	if len(values) != len(f.columns) {
+		return false
+	}
+
+	for i, column := range f.columns {
+		if values[i] != column {
+			return false
+		}
+	}
+
+	return true
+}
+
+// store - put values to payload, nothing is stored when record is invalid
+func (f *CsvFilter) store(payload map[string]string, values []string) error {
+	if len(values) != len(f.columns) {
+		return fmt.Errorf("expected %d columns, got %d", len(f.columns), len(values))
+	}
+
+	for i, column := range f.columns {
+		if column == "" {
+			continue
+		}
+
+		value, err := csvCoerce(values[i], f.types[column])
+		if err != nil {
+			return errors.New("column " + column + ": " + err.Error())
+		}
+
+		values[i] = value
+	}
+
+	for i, column := range f.columns {
+		if column != "" {
+			payload[f.prefix+column] = values[i]
+		}
+	}
+
+	return nil
+}
+
+// csvCoerce - normalize value of typed column, empty values are kept empty
+func csvCoerce(value string, columnType string) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	if columnType == "" || columnType == csvTypeString || trimmed == "" {
+		return value, nil
+	}
+
+	switch columnType {
+	case csvTypeInt:
+		v, err := strconv.ParseInt(trimmed, 10, 64)
+		if err != nil {
+			return "", errors.New("invalid int " + strconv.Quote(value))
+		}
+		return strconv.FormatInt(v, 10), nil
+	case csvTypeFloat:
+		v, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return "", errors.New("invalid float " + strconv.Quote(value))
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case csvTypeBool:
+		v, err := strconv.ParseBool(strings.ToLower(trimmed))
+		if err != nil {
+			return "", errors.New("invalid bool " + strconv.Quote(value))
+		}
+		return strconv.FormatBool(v), nil
+	}
+
+	return value, nil
+}
+
+/**
+ * Split record to values. Quoted part of value could contain delimiters, symbols
+ * after closing quote are appended to value
+ */
+func (f *CsvFilter) parse(source string) (values []string, err error) {
+	source = strings.TrimRight(source, "\r\n")
+
+	var value strings.Builder
+	quoted := false
+	escaped := false
+
+	for i, c := range source {
+		switch {
+		case escaped:
+			value.WriteRune(c)
+			escaped = false
+		case f.escape != 0 && c == f.escape:
+			escaped = true
+		case quoted && c == f.quote:
+			// doubled quote is a quote symbol
+			if next, _ := utf8.DecodeRuneInString(source[i+utf8.RuneLen(c):]); next == f.quote {
+				escaped = true
+				continue
+			}
+			quoted = false
+		case quoted:
+			value.WriteRune(c)
+		case f.quote != 0 && c == f.quote:
+			quoted = true
+		case c == f.delimiter:
+			values = append(values, value.String())
+			value.Reset()
+		default:
+			value.WriteRune(c)
+		}
+	}
+
+	if quoted || escaped {
+		return nil, errors.New("unterminated quoted value")
+	}
+
+	return append(values, value.String()), nil
+}
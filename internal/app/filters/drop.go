@@ -0,0 +1,94 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/expression"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"log"
+)
+
+// ConditionOptions - options of drop and keep filters
+type ConditionOptions struct {
+	Condition string `hcl:"condition"`
+}
+
+/**
+ * Condition filter drops messages matching expression, keep mode drops messages
+ * which don't match it. Expression syntax is described in expression package:
+ *
+ *	level == "debug" or "healthcheck" in tags
+ *	not (status >= 500 and hostname =~ "^web-")
+ */
+type ConditionFilter struct {
+	BasicFilter
+
+	condition *expression.Expression
+	keep      bool
+
+	log *log.Logger
+}
+
+func init() {
+	options := []registry.Option{
+		{Name: "condition", Description: "expression over payload fields, hostname and tags", Required: true},
+	}
+
+	registry.RegisterFilter("drop", registry.NewFilterFactory(ConditionOptions{}, NewDropFilter), registry.Schema{
+		Description: "Drop messages matching condition",
+		Options:     options,
+	})
+
+	registry.RegisterFilter("keep", registry.NewFilterFactory(ConditionOptions{}, NewKeepFilter), registry.Schema{
+		Description: "Keep messages matching condition, drop others",
+		Options:     options,
+	})
+}
+
+func NewDropFilter(options ConditionOptions, logger *log.Logger) (*ConditionFilter, error) {
+	return newConditionFilter(options, false, logger)
+}
+
+func NewKeepFilter(options ConditionOptions, logger *log.Logger) (*ConditionFilter, error) {
+	return newConditionFilter(options, true, logger)
+}
+
+func newConditionFilter(options ConditionOptions, keep bool, logger *log.Logger) (f *ConditionFilter, err error) {
+	f = &ConditionFilter{keep: keep}
+
+	if options.Condition == "" {
+		return nil, errors.New("empty condition")
+	}
+
+	f.condition, err = expression.Compile(options.Condition)
+	if err != nil {
+		return nil, errors.New("invalid condition: " + err.Error())
+	}
+
+	f.log = logger
+
+	return f, nil
+}
+
+func (f *ConditionFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Condition filter started, keep: %t, condition: %s", f.keep, f.condition)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		if f.condition.Match(msg) != f.keep {
+			f.Drop(msg)
+			continue
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
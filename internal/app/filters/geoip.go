@@ -2,6 +2,7 @@ package filters
 
 import (
 	"context"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"github.com/oschwald/geoip2-golang"
 	"log"
@@ -15,22 +16,30 @@ type GeoipFilter struct {
 	Database string
 	Lang     string
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewGeoipFilter(options map[string]string, logger log.Logger) (g *GeoipFilter, err error) {
-	g = &GeoipFilter{}
+// GeoipOptions - options of geoip filter
+type GeoipOptions struct {
+	Database string `hcl:"database"`
+	Lang     string `hcl:"lang,optional"`
+}
 
-	if database, ok := options["database"]; ok {
-		g.Database = database
-	}
+func init() {
+	registry.RegisterFilter("geoip", registry.NewFilterFactory(GeoipOptions{Lang: "en"}, NewGeoipFilter), registry.Schema{
+		Description: "Add geoip_* fields for IP address field",
+		Options: []registry.Option{
+			{Name: "database", Description: "path to MaxMind city database", Required: true, File: true},
+			{Name: "lang", Description: "language of city and region names", Default: "en"},
+		},
+	})
+}
 
-	if lang, ok := options["lang"]; ok {
-		g.Lang = lang
-	} else {
-		g.Lang = "en"
-	}
+func NewGeoipFilter(options GeoipOptions, logger *log.Logger) (g *GeoipFilter, err error) {
+	g = &GeoipFilter{}
 
+	g.Database = options.Database
+	g.Lang = options.Lang
 	g.log = logger
 
 	return g, nil
@@ -47,7 +56,10 @@ func (g *GeoipFilter) Proceed(ctx context.Context, input chan structs.Message, o
 	defer db.Close()
 
 	for ctx.Err() == nil {
-		msg, _ := g.ReadMessage(input)
+		msg, ok := g.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		if ip, ok := msg.Payload[g.Field]; ok {
 			ipNet := net.ParseIP(ip)
@@ -0,0 +1,340 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zclconf/go-cty/cty"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const grokDefaultErrorTag = "grok_error"
+
+var grokOnce = sync.Once{}
+var grokMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "filters",
+	Name:      "grok_matches",
+	Help:      "Total number of messages matched by grok pattern",
+}, []string{"pipeline", "filter", "pattern"})
+
+var (
+	// %{NAME}, %{NAME:field} or %{NAME:field:type}, type is ignored because payload values are strings
+	grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::\w+)?\}`)
+	// (?<field>...) and (?P<field>...) groups of patterns are captured like grok fields
+	grokNamedGroup = regexp.MustCompile(`\(\?P?<([^>=!]+)>`)
+)
+
+// grokLibrary - built-in patterns, they are parsed once and copied by every filter
+var grokLibrary = map[string]string{}
+
+// GrokOptions - pattern is single pattern or list of them
+type GrokOptions struct {
+	Pattern      cty.Value         `hcl:"pattern"`
+	PatternNames cty.Value         `hcl:"pattern_names,optional"`
+	PatternFiles cty.Value         `hcl:"pattern_files,optional"`
+	Definitions  map[string]string `hcl:"definitions,optional"`
+	ErrorTag     string            `hcl:"error_tag,optional"`
+}
+
+// grokPattern - compiled pattern with payload field of every subexpression, empty for groups which are not captured
+type grokPattern struct {
+	CountableRegexp
+
+	fields []string
+}
+
+/**
+ * Grok filter extracts fields with patterns like:
+ *
+ *	%{IPORHOST:client} \[%{HTTPDATE:time}\] "%{WORD:method} %{NOTSPACE:path}"
+ *
+ * Patterns are tried in order and the first matching one is used. Patterns are
+ * re-arranged by number of recent matches every service interval, so frequent
+ * formats are tried first. Messages matched by no pattern are tagged.
+ *
+ * HCL strings treat %{ as template directive, so references in configuration
+ * are escaped as %%{IPORHOST:client}. Pattern files are not escaped
+ */
+type GrokFilter struct {
+	BasicFilter
+
+	patterns []grokPattern
+	errorTag string
+
+	log *log.Logger
+}
+
+func init() {
+	if err := parseGrokPatterns(grokDefaultPatterns, grokLibrary); err != nil {
+		panic("grok: invalid built-in patterns: " + err.Error())
+	}
+
+	registry.RegisterFilter("grok", registry.NewFilterFactory(GrokOptions{ErrorTag: grokDefaultErrorTag}, NewGrokFilter), registry.Schema{
+		Description: "Extract fields with grok patterns, the first matching pattern is used",
+		Options: []registry.Option{
+			{Name: "pattern", Description: "grok pattern or list of patterns, written as %%{NAME:field} in HCL strings", Required: true},
+			{Name: "pattern_names", Description: "list of pattern names used in metrics, pattern index is used by default"},
+			{Name: "pattern_files", Description: "list of files with additional patterns, NAME REGEXP per line"},
+			{Name: "definitions", Description: "map of additional patterns, NAME = REGEXP"},
+			{Name: "error_tag", Description: "tag of messages matched by no pattern, empty disables tagging", Default: grokDefaultErrorTag},
+		},
+	})
+}
+
+func NewGrokFilter(options GrokOptions, logger *log.Logger) (f *GrokFilter, err error) {
+	f = &GrokFilter{errorTag: options.ErrorTag}
+	f.log = logger
+
+	var patterns []string
+	if !options.Pattern.IsNull() && options.Pattern.Type() == cty.String {
+		patterns = []string{options.Pattern.AsString()}
+	} else if patterns, err = registry.StringList(options.Pattern); err != nil {
+		return nil, errors.New("incorrect pattern: " + err.Error())
+	}
+
+	if len(patterns) == 0 {
+		return nil, errors.New("no patterns")
+	}
+
+	names, err := registry.StringList(options.PatternNames)
+	if err != nil {
+		return nil, errors.New("incorrect pattern_names: " + err.Error())
+	}
+
+	if len(names) > 0 && len(names) != len(patterns) {
+		return nil, fmt.Errorf("pattern_names should have name of every pattern, got %d names for %d patterns", len(names), len(patterns))
+	}
+
+	library := make(map[string]string, len(grokLibrary))
+	for name, pattern := range grokLibrary {
+		library[name] = pattern
+	}
+
+	files, err := registry.StringList(options.PatternFiles)
+	if err != nil {
+		return nil, errors.New("incorrect pattern_files: " + err.Error())
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("failed to read patterns: " + err.Error())
+		}
+
+		if err := parseGrokPatterns(string(content), library); err != nil {
+			return nil, errors.New("invalid patterns file " + file + ": " + err.Error())
+		}
+	}
+
+	for name, pattern := range options.Definitions {
+		library[name] = pattern
+	}
+
+	for i, pattern := range patterns {
+		compiled, err := compileGrok(pattern, library)
+		if err != nil {
+			return nil, errors.New("invalid pattern " + pattern + ": " + err.Error())
+		}
+
+		// pattern text is too long for metrics label
+		compiled.Name = strconv.Itoa(i)
+		if len(names) > 0 {
+			compiled.Name = names[i]
+		}
+
+		f.patterns = append(f.patterns, compiled)
+	}
+
+	return f, nil
+}
+
+// parseGrokPatterns - read pattern definitions, one NAME REGEXP per line, lines starting with # are comments
+func parseGrokPatterns(source string, library map[string]string) error {
+	for i, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, pattern, found := strings.Cut(line, " ")
+		if !found {
+			return fmt.Errorf("line %d: definition should be NAME REGEXP", i+1)
+		}
+
+		library[name] = strings.TrimSpace(pattern)
+	}
+
+	return nil
+}
+
+/**
+ * Grok compiler expands pattern references recursively. Captured fields are
+ * replaced with numbered groups, so field names could contain any symbols
+ */
+type grokCompiler struct {
+	library map[string]string
+	fields  []string
+}
+
+func compileGrok(pattern string, library map[string]string) (compiled grokPattern, err error) {
+	c := &grokCompiler{library: library}
+
+	expanded, err := c.expand(pattern, nil)
+	if err != nil {
+		return compiled, err
+	}
+
+	r, err := regexp.Compile(expanded)
+	if err != nil {
+		return compiled, err
+	}
+
+	compiled.Expression = *r
+	compiled.fields = make([]string, r.NumSubexp()+1)
+	for i, name := range r.SubexpNames() {
+		if index, err := strconv.Atoi(strings.TrimPrefix(name, "f")); err == nil && name != "" {
+			compiled.fields[i] = c.fields[index]
+		}
+	}
+
+	return compiled, nil
+}
+
+func (c *grokCompiler) expand(pattern string, stack []string) (expanded string, err error) {
+	pattern = grokNamedGroup.ReplaceAllStringFunc(pattern, func(group string) string {
+		return "(?P<" + c.field(grokNamedGroup.FindStringSubmatch(group)[1]) + ">"
+	})
+
+	expanded = grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+
+		match := grokReference.FindStringSubmatch(reference)
+		name, field := match[1], match[2]
+
+		definition, ok := c.library[name]
+		if !ok {
+			err = errors.New("unknown pattern " + name)
+			return ""
+		}
+
+		for _, v := range stack {
+			if v == name {
+				err = errors.New("recursive pattern " + strings.Join(append(stack, name), " -> "))
+				return ""
+			}
+		}
+
+		var inner string
+		if inner, err = c.expand(definition, append(stack, name)); err != nil {
+			return ""
+		}
+
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+
+		return "(?P<" + c.field(field) + ">" + inner + ")"
+	})
+
+	return expanded, err
+}
+
+// field - name of group capturing payload field
+func (c *grokCompiler) field(name string) string {
+	c.fields = append(c.fields, name)
+	return "f" + strconv.Itoa(len(c.fields)-1)
+}
+
+func (f *GrokFilter) Init() error {
+	grokOnce.Do(func() {
+		prometheus.MustRegister(grokMetrics)
+	})
+
+	return f.BasicFilter.Init()
+}
+
+func (f *GrokFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Grok filter activated. Total patterns: %d, target field: %s. Service interval: %d",
+		len(f.patterns), f.Field, f.ServiceInterval)
+
+	// every thread re-arranges own copy of patterns
+	patterns := make([]grokPattern, len(f.patterns))
+	copy(patterns, f.patterns)
+
+	j := 0
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		j += 1
+
+		value, ok := msg.Payload[f.Field]
+		if !ok {
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		matched := false
+		for i, p := range patterns {
+			match := p.Expression.FindStringSubmatchIndex(value)
+			if match == nil {
+				patterns[i].Fail()
+				continue
+			}
+
+			for group, field := range p.fields {
+				if field != "" && match[2*group] >= 0 {
+					msg.Payload[field] = value[match[2*group]:match[2*group+1]]
+				}
+			}
+
+			patterns[i].Match()
+			grokMetrics.WithLabelValues(f.Pipeline, f.Name, p.Name).Inc()
+			matched = true
+			break
+		}
+
+		if !matched {
+			f.Malformed(&msg, f.errorTag)
+		}
+
+		_ = f.WriteMessage(output, msg)
+
+		if j >= f.ServiceInterval && f.ServiceInterval > 0 {
+			j = 0
+			f.rearrange(patterns)
+		}
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// rearrange - move patterns with more recent matches first, old matches are reduced
+func (f *GrokFilter) rearrange(patterns []grokPattern) {
+	for i, p := range patterns {
+		if f.Debug {
+			f.log.Printf("OK: %d, FAIL: %d => %s", p.Matches, p.Fails, p.Name)
+		}
+		patterns[i].Reduce()
+	}
+
+	sort.SliceStable(patterns, func(a, b int) bool {
+		return patterns[a].Matches > patterns[b].Matches
+	})
+}
@@ -0,0 +1,172 @@
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonArraysEncode = "json"
+	jsonArraysIndex  = "index"
+
+	jsonDefaultSeparator = "."
+	jsonDefaultErrorTag  = "json_error"
+)
+
+// JsonOptions - options of json filter, parsed field is set by filter field attribute
+type JsonOptions struct {
+	Separator    string `hcl:"separator,optional"`
+	Prefix       string `hcl:"prefix,optional"`
+	Arrays       string `hcl:"arrays,optional"`
+	RemoveSource bool   `hcl:"remove_source,optional"`
+	ErrorTag     string `hcl:"error_tag,optional"`
+}
+
+/**
+ * Json filter parses JSON object from field to payload. Nested objects are
+ * flattened, {"http": {"status": 200}} becomes "http.status" = "200". Arrays
+ * are stored as JSON or flattened with item indexes, null values are skipped.
+ * Malformed messages are passed as is with error tag
+ */
+type JsonFilter struct {
+	BasicFilter
+
+	separator    string
+	prefix       string
+	indexArrays  bool
+	removeSource bool
+	errorTag     string
+
+	log *log.Logger
+}
+
+func init() {
+	defaults := JsonOptions{Separator: jsonDefaultSeparator, Arrays: jsonArraysEncode, ErrorTag: jsonDefaultErrorTag}
+
+	registry.RegisterFilter("json", registry.NewFilterFactory(defaults, NewJsonFilter), registry.Schema{
+		Description: "Parse JSON object from field to payload",
+		Options: []registry.Option{
+			{Name: "separator", Description: "separator of nested keys", Default: jsonDefaultSeparator},
+			{Name: "prefix", Description: "prefix of payload keys"},
+			{Name: "arrays", Description: "json - store arrays as JSON, index - flatten with item indexes", Default: jsonArraysEncode},
+			{Name: "remove_source", Description: "remove parsed field from payload", Default: "false"},
+			{Name: "error_tag", Description: "tag of messages which are not JSON objects, empty disables tagging", Default: jsonDefaultErrorTag},
+		},
+	})
+}
+
+func NewJsonFilter(options JsonOptions, logger *log.Logger) (f *JsonFilter, err error) {
+	f = &JsonFilter{}
+
+	switch options.Arrays {
+	case jsonArraysEncode:
+	case jsonArraysIndex:
+		f.indexArrays = true
+	default:
+		return nil, errors.New("unknown arrays mode: " + options.Arrays + ", should be json or index")
+	}
+
+	f.separator = options.Separator
+	f.prefix = options.Prefix
+	f.removeSource = options.RemoveSource
+	f.errorTag = options.ErrorTag
+	f.log = logger
+
+	return f, nil
+}
+
+func (f *JsonFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Json filter started. Field: %s, separator: %s, prefix: %s", f.Field, f.separator, f.prefix)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		source, ok := msg.Payload[f.Field]
+		if !ok {
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		object, err := decodeJsonObject(source)
+		if err != nil {
+			if f.Debug {
+				f.log.Printf("Failed to parse JSON: %s", err.Error())
+			}
+
+			f.Malformed(&msg, f.errorTag)
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		if f.removeSource {
+			delete(msg.Payload, f.Field)
+		}
+
+		for key, value := range object {
+			f.flatten(msg.Payload, f.prefix+key, value)
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// decodeJsonObject - decode single JSON object, numbers are kept as they are written
+func decodeJsonObject(source string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(source))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	if object == nil {
+		return nil, errors.New("not an object")
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after object")
+	}
+
+	return object, nil
+}
+
+func (f *JsonFilter) flatten(payload map[string]string, key string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		payload[key] = v
+	case json.Number:
+		payload[key] = v.String()
+	case bool:
+		payload[key] = strconv.FormatBool(v)
+	case map[string]interface{}:
+		for nestedKey, nestedValue := range v {
+			f.flatten(payload, key+f.separator+nestedKey, nestedValue)
+		}
+	case []interface{}:
+		if !f.indexArrays {
+			encoded, _ := json.Marshal(v)
+			payload[key] = string(encoded)
+			return
+		}
+
+		for i, item := range v {
+			f.flatten(payload, key+f.separator+strconv.Itoa(i), item)
+		}
+	}
+}
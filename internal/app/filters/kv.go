@@ -0,0 +1,288 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
+	"log"
+	"strings"
+)
+
+const (
+	kvDuplicateFirst  = "first"
+	kvDuplicateLast   = "last"
+	kvDuplicateAppend = "append"
+
+	kvDefaultPairSeparator   = " "
+	kvDefaultValueSeparator  = "="
+	kvDefaultAppendSeparator = ","
+	kvDefaultErrorTag        = "kv_error"
+)
+
+// KvOptions - options of kv filter, parsed field is set by filter field attribute
+type KvOptions struct {
+	PairSeparator   string    `hcl:"pair_separator,optional"`
+	ValueSeparator  string    `hcl:"value_separator,optional"`
+	Prefix          string    `hcl:"prefix,optional"`
+	Include         cty.Value `hcl:"include,optional"`
+	Exclude         cty.Value `hcl:"exclude,optional"`
+	Duplicates      string    `hcl:"duplicates,optional"`
+	AppendSeparator string    `hcl:"append_separator,optional"`
+	ErrorTag        string    `hcl:"error_tag,optional"`
+}
+
+/**
+ * Kv filter parses key=value pairs and logfmt lines to payload:
+ *
+ *	level=info msg="user \"bob\" logged in" duration=12ms
+ *
+ * Values could be quoted with double or single quotes, backslash escapes quote
+ * and itself. Key without separator gets empty value. Whitespace pair separator
+ * matches any number of spaces and tabs. Messages with unterminated quotes are
+ * passed as is with error tag
+ */
+type KvFilter struct {
+	BasicFilter
+
+	pairSeparator   string
+	valueSeparator  string
+	prefix          string
+	include         map[string]bool
+	exclude         map[string]bool
+	duplicates      string
+	appendSeparator string
+	errorTag        string
+
+	log *log.Logger
+}
+
+func init() {
+	defaults := KvOptions{
+		PairSeparator:   kvDefaultPairSeparator,
+		ValueSeparator:  kvDefaultValueSeparator,
+		Duplicates:      kvDuplicateLast,
+		AppendSeparator: kvDefaultAppendSeparator,
+		ErrorTag:        kvDefaultErrorTag,
+	}
+
+	registry.RegisterFilter("kv", registry.NewFilterFactory(defaults, NewKvFilter), registry.Schema{
+		Description: "Parse key=value pairs and logfmt from field to payload",
+		Options: []registry.Option{
+			{Name: "pair_separator", Description: "separator of pairs, space matches any whitespace", Default: kvDefaultPairSeparator},
+			{Name: "value_separator", Description: "separator of key and value", Default: kvDefaultValueSeparator},
+			{Name: "prefix", Description: "prefix of payload keys"},
+			{Name: "include", Description: "list of keys which are stored, all keys by default"},
+			{Name: "exclude", Description: "list of keys which are skipped"},
+			{Name: "duplicates", Description: "value of repeated key: first, last or append", Default: kvDuplicateLast},
+			{Name: "append_separator", Description: "separator of appended duplicate values", Default: kvDefaultAppendSeparator},
+			{Name: "error_tag", Description: "tag of messages which could not be parsed, empty disables tagging", Default: kvDefaultErrorTag},
+		},
+	})
+}
+
+func NewKvFilter(options KvOptions, logger *log.Logger) (f *KvFilter, err error) {
+	f = &KvFilter{}
+
+	if options.PairSeparator == "" || options.ValueSeparator == "" {
+		return nil, errors.New("empty pair or value separator")
+	}
+
+	if options.PairSeparator == options.ValueSeparator {
+		return nil, errors.New("pair and value separators should differ")
+	}
+
+	switch options.Duplicates {
+	case kvDuplicateFirst, kvDuplicateLast, kvDuplicateAppend:
+	default:
+		return nil, errors.New("unknown duplicates mode: " + options.Duplicates + ", should be first, last or append")
+	}
+
+	if f.include, err = kvKeySet(options.Include); err != nil {
+		return nil, errors.New("incorrect include: " + err.Error())
+	}
+
+	if f.exclude, err = kvKeySet(options.Exclude); err != nil {
+		return nil, errors.New("incorrect exclude: " + err.Error())
+	}
+
+	f.pairSeparator = options.PairSeparator
+	f.valueSeparator = options.ValueSeparator
+	f.prefix = options.Prefix
+	f.duplicates = options.Duplicates
+	f.appendSeparator = options.AppendSeparator
+	f.errorTag = options.ErrorTag
+	f.log = logger
+
+	return f, nil
+}
+
+func kvKeySet(value cty.Value) (map[string]bool, error) {
+	if value.IsNull() {
+		return nil, nil
+	}
+
+	keys, err := registry.StringList(value)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+
+	return set, nil
+}
+
+func (f *KvFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Kv filter started. Field: %s, pair separator: %q, value separator: %q, duplicates: %s",
+		f.Field, f.pairSeparator, f.valueSeparator, f.duplicates)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		source, ok := msg.Payload[f.Field]
+		if !ok {
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		pairs, err := f.parse(source)
+		if err != nil {
+			if f.Debug {
+				f.log.Printf("Failed to parse pairs: %s", err.Error())
+			}
+
+			f.Malformed(&msg, f.errorTag)
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		// duplicates are detected among parsed keys only, existing payload fields are overwritten
+		seen := make(map[string]bool, len(pairs))
+		for _, pair := range pairs {
+			key := f.prefix + pair[0]
+
+			if seen[key] {
+				switch f.duplicates {
+				case kvDuplicateFirst:
+					continue
+				case kvDuplicateAppend:
+					msg.Payload[key] += f.appendSeparator + pair[1]
+					continue
+				}
+			}
+
+			seen[key] = true
+			msg.Payload[key] = pair[1]
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// parse - key and value pairs in order of appearance, filtered by include and exclude lists
+func (f *KvFilter) parse(source string) (pairs [][2]string, err error) {
+	pos := 0
+
+	for {
+		pos = f.skipPairSeparators(source, pos)
+		if pos >= len(source) {
+			return pairs, nil
+		}
+
+		// key ends with value separator, pair separator or end of line
+		end := pos
+		for end < len(source) && !strings.HasPrefix(source[end:], f.valueSeparator) && !f.isPairSeparator(source, end) {
+			end += 1
+		}
+		key := strings.TrimSpace(source[pos:end])
+		pos = end
+
+		value := ""
+		if strings.HasPrefix(source[pos:], f.valueSeparator) {
+			pos += len(f.valueSeparator)
+
+			if pos < len(source) && (source[pos] == '"' || source[pos] == '\'') {
+				value, pos, err = kvQuoted(source, pos)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				end = pos
+				for end < len(source) && !f.isPairSeparator(source, end) {
+					end += 1
+				}
+				value = source[pos:end]
+				pos = end
+			}
+		}
+
+		if key == "" || (f.include != nil && !f.include[key]) || f.exclude[key] {
+			continue
+		}
+
+		pairs = append(pairs, [2]string{key, value})
+	}
+}
+
+func (f *KvFilter) isPairSeparator(source string, pos int) bool {
+	if f.pairSeparator == " " {
+		return source[pos] == ' ' || source[pos] == '\t'
+	}
+
+	return strings.HasPrefix(source[pos:], f.pairSeparator)
+}
+
+func (f *KvFilter) skipPairSeparators(source string, pos int) int {
+	for pos < len(source) && f.isPairSeparator(source, pos) {
+		if f.pairSeparator == " " {
+			pos += 1
+		} else {
+			pos += len(f.pairSeparator)
+		}
+	}
+
+	return pos
+}
+
+// kvQuoted - unquote value starting at pos, returns position after closing quote
+func kvQuoted(source string, pos int) (string, int, error) {
+	quote := source[pos]
+
+	var value strings.Builder
+	for i := pos + 1; i < len(source); i += 1 {
+		switch c := source[i]; {
+		case c == '\\' && i+1 < len(source):
+			i += 1
+			switch source[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			case '\\', '"', '\'':
+				value.WriteByte(source[i])
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(source[i])
+			}
+		case c == quote:
+			return value.String(), i + 1, nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return "", len(source), errors.New("unterminated quoted value")
+}
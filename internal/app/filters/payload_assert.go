@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 )
@@ -12,10 +13,17 @@ type PayloadAssertFilter struct {
 
 	FieldOptions map[string]string
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewPayloadAssertFilter(options map[string]string, logger log.Logger) (f *PayloadAssertFilter, err error) {
+func init() {
+	registry.RegisterFilter("payload_assert", registry.NewFilterFactory(map[string]string{}, NewPayloadAssertFilter), registry.Schema{
+		Description: "Drop messages without required or with absent fields",
+		Mapping:     "field name = required or absent",
+	})
+}
+
+func NewPayloadAssertFilter(options map[string]string, logger *log.Logger) (f *PayloadAssertFilter, err error) {
 	f = &PayloadAssertFilter{}
 	for key, value := range options {
 		switch value {
@@ -38,7 +46,10 @@ func (f *PayloadAssertFilter) Proceed(ctx context.Context, input chan structs.Me
 
 messageLoop:
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		for fieldName, status := range f.FieldOptions {
 			switch status {
@@ -48,11 +59,13 @@ messageLoop:
 						f.log.Printf("FILTERED: %s", msg.Payload["content"])
 					}
 
+					f.Drop(msg)
 					continue messageLoop
 				}
 				break
 			case "absent":
 				if _, ok := msg.Payload[fieldName]; ok {
+					f.Drop(msg)
 					continue messageLoop
 				}
 				break
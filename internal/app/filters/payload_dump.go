@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"encoding/json"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 )
@@ -10,10 +11,19 @@ import (
 type PayloadDumpFilter struct {
 	BasicFilter
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewPayloadDumpFilter(options map[string]string, logger log.Logger) (f *PayloadDumpFilter, err error) {
+// PayloadDumpOptions - payload_dump filter has no options
+type PayloadDumpOptions struct{}
+
+func init() {
+	registry.RegisterFilter("payload_dump", registry.NewFilterFactory(PayloadDumpOptions{}, NewPayloadDumpFilter), registry.Schema{
+		Description: "Log message payload",
+	})
+}
+
+func NewPayloadDumpFilter(options PayloadDumpOptions, logger *log.Logger) (f *PayloadDumpFilter, err error) {
 	f = &PayloadDumpFilter{}
 
 	f.log = logger
@@ -25,10 +35,14 @@ func (f *PayloadDumpFilter) Proceed(ctx context.Context, input chan structs.Mess
 	f.log.Printf("started payload_dump filter")
 
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		jsonData, err := json.MarshalIndent(msg.Payload, "", "    ")
 		if err != nil {
+			f.Reject(msg, err)
 			continue
 		}
 
@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 )
@@ -12,10 +13,17 @@ type PayloadEqualFilter struct {
 
 	Options map[string]string
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewPayloadEqualFilter(options map[string]string, logger log.Logger) (f *PayloadEqualFilter, err error) {
+func init() {
+	registry.RegisterFilter("payload_equal", registry.NewFilterFactory(map[string]string{}, NewPayloadEqualFilter), registry.Schema{
+		Description: "Pass messages with any of fields equal to value, drop others",
+		Mapping:     "field name = expected value",
+	})
+}
+
+func NewPayloadEqualFilter(options map[string]string, logger *log.Logger) (f *PayloadEqualFilter, err error) {
 	f = &PayloadEqualFilter{}
 
 	f.Options = options
@@ -33,24 +41,31 @@ func (f *PayloadEqualFilter) Proceed(ctx context.Context, input chan structs.Mes
 	f.log.Printf("started payload_equal filter. Total items: %d", len(f.Options))
 
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
-
-		for key, value := range f.Options {
-			if _, ok := msg.Payload[key]; !ok {
-				continue
-			}
-
-			if value != msg.Payload[key] {
-				continue
-			}
-
-			f.log.Printf("processing message: %s", msg.Payload["ipv4"])
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
-			f.WriteMessage(output, msg)
+		if !f.match(msg) {
+			f.Drop(msg)
+			continue
 		}
+
+		_ = f.WriteMessage(output, msg)
 	}
 
 	f.log.Printf("Channel processing finished. Exiting")
 
 	return
 }
+
+// match - any of fields is present and equal to expected value
+func (f *PayloadEqualFilter) match(msg structs.Message) bool {
+	for key, value := range f.Options {
+		if v, ok := msg.Payload[key]; ok && v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"github.com/prometheus/client_golang/prometheus"
 	"log"
@@ -17,7 +18,7 @@ type RegexpFilter struct {
 
 	RegexpList map[string]string
 
-	log log.Logger
+	log *log.Logger
 }
 
 var regexpOnce = sync.Once{}
@@ -25,7 +26,7 @@ var regexpMetrics = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 	Namespace: "ll",
 	Subsystem: "filters",
 	Name:      "regexp_matches",
-}, []string{"filter", "rule"})
+}, []string{"pipeline", "filter", "rule"})
 
 // this coef is used to reduce number of matches so in fact we will
 const REDUCECOEF = 0.99
@@ -52,7 +53,14 @@ func (r *CountableRegexp) Reduce() {
 	r.Matches = int(math.Floor(float64(r.Matches) * REDUCECOEF))
 }
 
-func NewRegexpFilter(options map[string]string, logger log.Logger) (f *RegexpFilter, err error) {
+func init() {
+	registry.RegisterFilter("regexp", registry.NewFilterFactory(map[string]string{}, NewRegexpFilter), registry.Schema{
+		Description: "Extract named groups of the first matching regexp to payload",
+		Mapping:     "regexp name = regular expression with named groups",
+	})
+}
+
+func NewRegexpFilter(options map[string]string, logger *log.Logger) (f *RegexpFilter, err error) {
 	f = &RegexpFilter{}
 
 	f.log = logger
@@ -60,7 +68,7 @@ func NewRegexpFilter(options map[string]string, logger log.Logger) (f *RegexpFil
 		f.log.Printf("Compiling regexp %s => %s", k, expression)
 		_, err := regexp.Compile(strings.Trim(expression, " \n\t\r"))
 		if err != nil {
-			return nil, errors.New("regexp compilation failed:" + err.Error())
+			return nil, errors.New("regexp compilation failed: " + err.Error())
 		}
 	}
 
@@ -97,7 +105,10 @@ func (f *RegexpFilter) Proceed(ctx context.Context, input chan structs.Message,
 	sortPos := 0
 	processed := 0
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		j += 1
 		processed += 1
@@ -146,7 +157,7 @@ func (f *RegexpFilter) Proceed(ctx context.Context, input chan structs.Message,
 				}
 				expList[i].Reduce()
 
-				regexpMetrics.WithLabelValues(f.Name, e.Name).Set(float64(e.Matches))
+				regexpMetrics.WithLabelValues(f.Pipeline, f.Name, e.Name).Set(float64(e.Matches))
 			}
 
 			if expList[sortPos].Matches < expList[sortPos+1].Matches {
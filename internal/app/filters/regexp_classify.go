@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	hcl "github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,7 +20,7 @@ var regexpClassifyMetrics = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 	Namespace: "ll",
 	Subsystem: "filters",
 	Name:      "regexp_classify_matches",
-}, []string{"filter", "rule"})
+}, []string{"pipeline", "filter", "rule"})
 
 /**
  * How this module is working:
@@ -76,23 +77,39 @@ type RegexpClassifyFilter struct {
 
 	Expression regexp.Regexp
 	Rules      []RegexpClassifyRule
-	log        log.Logger
+	log        *log.Logger
 }
 
-func NewRegexpClassifyFilter(options map[string]string, logger log.Logger) (f *RegexpClassifyFilter, err error) {
-	f = &RegexpClassifyFilter{}
+// RegexpClassifyOptions - rules file and inline rule blocks, file rules are added after inline ones
+type RegexpClassifyOptions struct {
+	Rules string                  `hcl:"rules,optional"`
+	Rule  []RegexpClassifyRuleRaw `hcl:"rule,block"`
+}
+
+func init() {
+	registry.RegisterFilter("regexp_classify", registry.NewFilterFactory(RegexpClassifyOptions{}, NewRegexpClassifyFilter), registry.Schema{
+		Description: "Set fields by classification rules",
+		Options: []registry.Option{
+			{Name: "rules", Description: "path to HCL file with classification rules", File: true},
+			{Name: "rule", Description: "inline classification rule block, same as rule of rules file"},
+		},
+	})
+}
 
-	var classesPath string
-	var ok bool
+func NewRegexpClassifyFilter(options RegexpClassifyOptions, logger *log.Logger) (f *RegexpClassifyFilter, err error) {
+	f = &RegexpClassifyFilter{}
 
-	if classesPath, ok = options["rules"]; !ok {
+	if options.Rules == "" && len(options.Rule) == 0 {
 		return nil, errors.New("no regexp_classify rules available")
 	}
 
 	conf := &RegexpClassifyConfig{}
-	if err := hcl.DecodeFile(classesPath, nil, conf); err != nil {
-		return nil, err
+	if options.Rules != "" {
+		if err := hcl.DecodeFile(options.Rules, nil, conf); err != nil {
+			return nil, err
+		}
 	}
+	conf.Rules = append(options.Rule, conf.Rules...)
 
 	logger.Printf("loaded classification rules. Total rules: %d", len(conf.Rules))
 
@@ -111,7 +128,12 @@ func NewRegexpClassifyFilter(options map[string]string, logger log.Logger) (f *R
 		rule := RegexpClassifyRule{}
 
 		f.log.Printf("Compiling rule %s", conf.Rules[key].Expression)
-		rule.Expression = *regexp.MustCompile(strings.Trim(conf.Rules[key].Expression, " \n\t\r"))
+		compiled, err := regexp.Compile(strings.Trim(conf.Rules[key].Expression, " \n\t\r"))
+		if err != nil {
+			return nil, errors.New("rule " + conf.Rules[key].Name + " compilation failed: " + err.Error())
+		}
+
+		rule.Expression = *compiled
 		rule.Name = conf.Rules[key].Name
 		rule.Fields = conf.Rules[key].Fields.Data
 		rule.Backref = conf.Rules[key].Backref
@@ -142,7 +164,10 @@ func (f *RegexpClassifyFilter) Proceed(ctx context.Context, input chan structs.M
 
 	i := 0
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		i += 1
 
@@ -189,7 +214,7 @@ func (f *RegexpClassifyFilter) Proceed(ctx context.Context, input chan structs.M
 					fields[matchField] = matchValue
 				}
 
-				regexpClassifyMetrics.WithLabelValues(f.GetName(), v.Name).Inc()
+				regexpClassifyMetrics.WithLabelValues(f.Pipeline, f.GetName(), v.Name).Inc()
 			}
 		}
 
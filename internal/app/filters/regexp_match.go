@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"regexp"
@@ -16,38 +17,48 @@ type RegexpMatchFilter struct {
 	Action      string
 	TargetField string
 	TargetValue string
-	log         log.Logger
+	log         *log.Logger
 }
 
-func NewRegexpMatchFilter(options map[string]string, logger log.Logger) (f *RegexpMatchFilter, err error) {
-	f = &RegexpMatchFilter{}
-	if action, ok := options["action"]; ok {
-		if action != "set" {
-			return nil, errors.New("unknown action: " + f.Action)
-		}
+// RegexpMatchOptions - options of regexp_match filter
+type RegexpMatchOptions struct {
+	Action      string `hcl:"action"`
+	Expression  string `hcl:"expression"`
+	TargetField string `hcl:"target_field"`
+	TargetValue string `hcl:"target_value"`
+}
 
-		f.Action = action
-	} else {
-		return nil, errors.New("no action passed")
-	}
+func init() {
+	registry.RegisterFilter("regexp_match", registry.NewFilterFactory(RegexpMatchOptions{}, NewRegexpMatchFilter), registry.Schema{
+		Description: "Set field when value matches regexp",
+		Options: []registry.Option{
+			{Name: "action", Description: "action for matching messages, only set is supported", Required: true},
+			{Name: "expression", Description: "regular expression", Required: true},
+			{Name: "target_field", Description: "field to set", Required: true},
+			{Name: "target_value", Description: "value to set", Required: true},
+		},
+	})
+}
 
-	if fieldName, ok := options["target_field"]; ok {
-		f.TargetField = fieldName
-	} else {
-		return nil, errors.New("no target field")
+func NewRegexpMatchFilter(options RegexpMatchOptions, logger *log.Logger) (f *RegexpMatchFilter, err error) {
+	f = &RegexpMatchFilter{}
+	if options.Action != "set" {
+		return nil, errors.New("unknown action: " + options.Action)
 	}
+	f.Action = options.Action
 
-	if fieldValue, ok := options["target_value"]; ok && fieldValue != "" {
-		f.TargetValue = fieldValue
-	} else {
+	if options.TargetValue == "" {
 		return nil, errors.New("no target value")
 	}
+	f.TargetField = options.TargetField
+	f.TargetValue = options.TargetValue
 
-	if expression, ok := options["expression"]; ok {
-		f.Expression = *regexp.MustCompile(strings.Trim(expression, " \n\t\r"))
-	} else {
-		return nil, errors.New("no expression")
+	compiled, err := regexp.Compile(strings.Trim(options.Expression, " \n\t\r"))
+	if err != nil {
+		return nil, errors.New("expression compilation failed: " + err.Error())
 	}
+
+	f.Expression = *compiled
 	f.log = logger
 
 	return f, nil
@@ -59,7 +70,10 @@ func (f *RegexpMatchFilter) Proceed(ctx context.Context, input chan structs.Mess
 		f.Expression.String(), f.Action, f.TargetField, f.TargetValue)
 
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		// skip records without target field
 		if _, ok := msg.Payload[f.Field]; !ok {
@@ -2,6 +2,8 @@ package filters
 
 import (
 	"context"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"regexp"
@@ -12,17 +14,29 @@ type RegexpRemoveFilter struct {
 	BasicFilter
 
 	Expressions map[string]regexp.Regexp
-	log         log.Logger
+	log         *log.Logger
 }
 
-func NewRegexpRemoveFilter(options map[string]string, logger log.Logger) (f *RegexpRemoveFilter, err error) {
+func init() {
+	registry.RegisterFilter("regexp_remove", registry.NewFilterFactory(map[string]string{}, NewRegexpRemoveFilter), registry.Schema{
+		Description: "Drop messages matching any of regexps",
+		Mapping:     "regexp name = regular expression",
+	})
+}
+
+func NewRegexpRemoveFilter(options map[string]string, logger *log.Logger) (f *RegexpRemoveFilter, err error) {
 	f = &RegexpRemoveFilter{}
 	f.Expressions = make(map[string]regexp.Regexp, len(options))
 
 	f.log = logger
 	for k, expression := range options {
 		f.log.Printf("Compiling regexp %s => %s", k, expression)
-		f.Expressions[k] = *regexp.MustCompile(strings.Trim(expression, " \n\t\r"))
+		compiled, err := regexp.Compile(strings.Trim(expression, " \n\t\r"))
+		if err != nil {
+			return nil, errors.New("regexp " + k + " compilation failed: " + err.Error())
+		}
+
+		f.Expressions[k] = *compiled
 	}
 
 	return f, nil
@@ -35,10 +49,15 @@ func (f *RegexpRemoveFilter) Proceed(ctx context.Context, input chan structs.Mes
 	f.log.Printf("Regexp remove filter activated. Total regexp: %d, target field: %s", len(f.Expressions), f.Field)
 
 messageLoop:
-	for msg := range input {
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		// skip records without target field
 		if _, ok := msg.Payload[f.Field]; !ok {
-			output <- msg
+			_ = f.WriteMessage(output, msg)
 			continue
 		}
 
@@ -46,11 +65,12 @@ messageLoop:
 			match := expression.FindStringSubmatch(msg.Payload[f.Field])
 			if match != nil {
 				// f.log.Printf("removing %s, expr: %s", msg.Payload[f.Field], expression.String())
+				f.Drop(msg)
 				continue messageLoop
 			}
 		}
 
-		output <- msg
+		_ = f.WriteMessage(output, msg)
 	}
 
 	f.log.Printf("Channel processing finished. Exiting")
@@ -2,6 +2,7 @@ package filters
 
 import (
 	"context"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 )
@@ -10,10 +11,17 @@ type RenameFilter struct {
 	BasicFilter
 
 	RenameMap map[string]string
-	log       log.Logger
+	log       *log.Logger
 }
 
-func NewRenameFilter(options map[string]string, logger log.Logger) (f *RenameFilter, err error) {
+func init() {
+	registry.RegisterFilter("rename", registry.NewFilterFactory(map[string]string{}, NewRenameFilter), registry.Schema{
+		Description: "Rename payload fields",
+		Mapping:     "source field = new field name",
+	})
+}
+
+func NewRenameFilter(options map[string]string, logger *log.Logger) (f *RenameFilter, err error) {
 	f = &RenameFilter{}
 	f.RenameMap = options
 	f.log = logger
@@ -27,7 +35,12 @@ func NewRenameFilter(options map[string]string, logger log.Logger) (f *RenameFil
 func (f *RenameFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
 	f.log.Printf("Rename filter activated. Total renames: %d", len(f.RenameMap))
 
-	for msg := range input {
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		payload := msg.Payload
 
 		for from, to := range f.RenameMap {
@@ -37,7 +50,7 @@ func (f *RenameFilter) Proceed(ctx context.Context, input chan structs.Message,
 			}
 		}
 		msg.Payload = payload
-		output <- msg
+		_ = f.WriteMessage(output, msg)
 	}
 
 	f.log.Printf("Channel processing finished. Exiting")
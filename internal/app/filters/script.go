@@ -0,0 +1,344 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	scriptDefaultFunction = "process"
+	scriptDefaultTimeout  = "100ms"
+)
+
+// ScriptOptions - script is passed inline with source or read from file
+type ScriptOptions struct {
+	Source   string `hcl:"source,optional"`
+	File     string `hcl:"file,optional"`
+	Function string `hcl:"function,optional"`
+	Timeout  string `hcl:"timeout,optional"`
+}
+
+/**
+ * Script filter calls Starlark function for every message:
+ *
+ *	def process(msg, state):
+ *	    msg["payload"]["size"] = str(len(msg["payload"]["content"]))
+ *	    return msg
+ *
+ * Message is passed as dict with hostname, content, tags and payload keys. Function
+ * returns None to drop message, message dict or list of messages. State is dict
+ * kept between calls, every thread of filter has own state and globals. Call which
+ * takes longer than timeout is cancelled and message is rejected
+ */
+type ScriptFilter struct {
+	BasicFilter
+
+	source   string
+	filename string
+	function string
+	timeout  time.Duration
+
+	log *log.Logger
+}
+
+func init() {
+	defaults := ScriptOptions{Function: scriptDefaultFunction, Timeout: scriptDefaultTimeout}
+
+	registry.RegisterFilter("script", registry.NewFilterFactory(defaults, NewScriptFilter), registry.Schema{
+		Description: "Process messages with Starlark script",
+		Options: []registry.Option{
+			{Name: "source", Description: "script source, used instead of file"},
+			{Name: "file", Description: "path to script file", File: true},
+			{Name: "function", Description: "function called with message and state", Default: scriptDefaultFunction},
+			{Name: "timeout", Description: "maximum duration of function call", Default: scriptDefaultTimeout},
+		},
+	})
+}
+
+func NewScriptFilter(options ScriptOptions, logger *log.Logger) (f *ScriptFilter, err error) {
+	f = &ScriptFilter{function: options.Function}
+	f.log = logger
+
+	switch {
+	case options.Source != "" && options.File != "":
+		return nil, errors.New("only one of source and file should be set")
+	case options.Source != "":
+		f.source = options.Source
+		f.filename = "script"
+	case options.File != "":
+		content, err := os.ReadFile(options.File)
+		if err != nil {
+			return nil, errors.New("failed to read script: " + err.Error())
+		}
+		f.source = string(content)
+		f.filename = options.File
+	default:
+		return nil, errors.New("no script source or file")
+	}
+
+	f.timeout, err = registry.ParseDuration(options.Timeout)
+	if err != nil || f.timeout <= 0 {
+		return nil, errors.New("incorrect timeout: " + options.Timeout)
+	}
+
+	// script is loaded once to report errors on start instead of every thread
+	if _, err := f.newRuntime(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// scriptDialect - loops are allowed, because calls are limited by timeout anyway
+var scriptDialect = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+
+// scriptRuntime - interpreter thread with loaded script, used by one filter thread
+type scriptRuntime struct {
+	thread   *starlark.Thread
+	function starlark.Value
+	state    *starlark.Dict
+}
+
+func (f *ScriptFilter) newRuntime() (*scriptRuntime, error) {
+	r := &scriptRuntime{state: starlark.NewDict(0)}
+	r.thread = &starlark.Thread{
+		Name: f.GetName(),
+		Print: func(thread *starlark.Thread, msg string) {
+			f.log.Printf("Script %s: %s", f.filename, msg)
+		},
+	}
+
+	predeclared := starlark.StringDict{"json": json.Module}
+
+	var globals starlark.StringDict
+	err := f.limit(r.thread, func() (err error) {
+		globals, err = starlark.ExecFileOptions(scriptDialect, r.thread, f.filename, f.source, predeclared)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("failed to load script: " + err.Error())
+	}
+
+	r.function = globals[f.function]
+	if _, ok := r.function.(starlark.Callable); !ok {
+		return nil, errors.New("script has no function " + f.function)
+	}
+
+	return r, nil
+}
+
+/**
+ * Run call with time limit, interpreter thread is cancelled when limit is
+ * exceeded and could be reused after that
+ */
+func (f *ScriptFilter) limit(thread *starlark.Thread, call func() error) error {
+	cancelled := make(chan struct{})
+	timer := time.AfterFunc(f.timeout, func() {
+		thread.Cancel("timeout " + f.timeout.String() + " exceeded")
+		close(cancelled)
+	})
+
+	err := call()
+
+	if !timer.Stop() {
+		<-cancelled
+	}
+	thread.Uncancel()
+
+	return err
+}
+
+func (f *ScriptFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	runtime, err := f.newRuntime()
+	if err != nil {
+		return err
+	}
+
+	f.log.Printf("Script filter started. Script: %s, function: %s, timeout: %s", f.filename, f.function, f.timeout)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		messages, err := f.process(runtime, msg)
+		if err != nil {
+			if f.Debug {
+				f.log.Printf("Script failed: %s", err.Error())
+			}
+			f.Reject(msg, err)
+			continue
+		}
+
+		if len(messages) == 0 {
+			f.Drop(msg)
+			continue
+		}
+
+		if len(messages) > 1 && msg.AckFunc != nil {
+			ack := structs.ShareAck(msg.AckFunc, len(messages))
+			for i := range messages {
+				messages[i].AckFunc = ack
+			}
+		}
+
+		for _, v := range messages {
+			_ = f.WriteMessage(output, v)
+		}
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// process - call script function and convert its result to messages
+func (f *ScriptFilter) process(r *scriptRuntime, msg structs.Message) (messages []structs.Message, err error) {
+	var result starlark.Value
+	err = f.limit(r.thread, func() (err error) {
+		result, err = starlark.Call(r.thread, r.function, starlark.Tuple{scriptMessage(msg), r.state}, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := result.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.Dict:
+		converted, err := messageFromScript(v, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		return []structs.Message{converted}, nil
+	case *starlark.List:
+		for i := 0; i < v.Len(); i += 1 {
+			item, ok := v.Index(i).(*starlark.Dict)
+			if !ok {
+				return nil, fmt.Errorf("message #%d should be dict, got %s", i, v.Index(i).Type())
+			}
+
+			converted, err := messageFromScript(item, msg)
+			if err != nil {
+				return nil, fmt.Errorf("message #%d: %s", i, err.Error())
+			}
+
+			messages = append(messages, converted)
+		}
+
+		return messages, nil
+	}
+
+	return nil, errors.New("function should return None, dict or list of dicts, got " + result.Type())
+}
+
+// scriptMessage - message as Starlark dict
+func scriptMessage(msg structs.Message) *starlark.Dict {
+	payload := starlark.NewDict(len(msg.Payload))
+	for key, value := range msg.Payload {
+		_ = payload.SetKey(starlark.String(key), starlark.String(value))
+	}
+
+	tags := make([]starlark.Value, 0, len(msg.Tags))
+	for _, tag := range msg.Tags {
+		tags = append(tags, starlark.String(tag))
+	}
+
+	d := starlark.NewDict(4)
+	_ = d.SetKey(starlark.String("hostname"), starlark.String(msg.Hostname))
+	_ = d.SetKey(starlark.String("content"), starlark.String(msg.Content))
+	_ = d.SetKey(starlark.String("tags"), starlark.NewList(tags))
+	_ = d.SetKey(starlark.String("payload"), payload)
+
+	return d
+}
+
+/**
+ * Message from dict returned by script, missing keys are taken from source message.
+ * Payload values which are not strings are formatted, None values are removed
+ */
+func messageFromScript(d *starlark.Dict, source structs.Message) (msg structs.Message, err error) {
+	msg = source
+
+	if msg.Hostname, err = scriptString(d, "hostname", source.Hostname); err != nil {
+		return msg, err
+	}
+
+	if msg.Content, err = scriptString(d, "content", source.Content); err != nil {
+		return msg, err
+	}
+
+	if value, found, _ := d.Get(starlark.String("tags")); found {
+		iterable, ok := value.(starlark.Iterable)
+		if !ok {
+			return msg, errors.New("tags should be list, got " + value.Type())
+		}
+
+		msg.Tags = nil
+		iterator := iterable.Iterate()
+		var tag starlark.Value
+		for iterator.Next(&tag) {
+			s, ok := starlark.AsString(tag)
+			if !ok {
+				iterator.Done()
+				return msg, errors.New("tag should be string, got " + tag.Type())
+			}
+			msg.Tags = append(msg.Tags, s)
+		}
+		iterator.Done()
+	}
+
+	value, found, _ := d.Get(starlark.String("payload"))
+	if !found {
+		return msg, errors.New("no payload")
+	}
+
+	payload, ok := value.(*starlark.Dict)
+	if !ok {
+		return msg, errors.New("payload should be dict, got " + value.Type())
+	}
+
+	msg.Payload = make(map[string]string, payload.Len())
+	for _, item := range payload.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return msg, errors.New("payload key should be string, got " + item[0].Type())
+		}
+
+		switch v := item[1].(type) {
+		case starlark.NoneType:
+		case starlark.String:
+			msg.Payload[key] = string(v)
+		default:
+			msg.Payload[key] = v.String()
+		}
+	}
+
+	return msg, nil
+}
+
+func scriptString(d *starlark.Dict, key string, fallback string) (string, error) {
+	value, found, _ := d.Get(starlark.String(key))
+	if !found {
+		return fallback, nil
+	}
+
+	s, ok := starlark.AsString(value)
+	if !ok {
+		return "", errors.New(key + " should be string, got " + value.Type())
+	}
+
+	return s, nil
+}
@@ -2,6 +2,7 @@ package filters
 
 import (
 	"context"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 )
@@ -11,10 +12,17 @@ type SetFilter struct {
 
 	Updates map[string]string
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewSetFilter(options map[string]string, logger log.Logger) (s *SetFilter, err error) {
+func init() {
+	registry.RegisterFilter("set", registry.NewFilterFactory(map[string]string{}, NewSetFilter), registry.Schema{
+		Description: "Set fields to constant values",
+		Mapping:     "field name = value",
+	})
+}
+
+func NewSetFilter(options map[string]string, logger *log.Logger) (s *SetFilter, err error) {
 	s = &SetFilter{}
 
 	s.Updates = options
@@ -29,7 +37,12 @@ func NewSetFilter(options map[string]string, logger log.Logger) (s *SetFilter, e
 func (s *SetFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
 	s.log.Printf("Set filter started. Total items: %d", len(s.Updates))
 
-	for msg := range input {
+	for ctx.Err() == nil {
+		msg, ok := s.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		payload := msg.Payload
 
 		for key, value := range s.Updates {
@@ -37,7 +50,7 @@ func (s *SetFilter) Proceed(ctx context.Context, input chan structs.Message, out
 		}
 		msg.Payload = payload
 
-		output <- msg
+		_ = s.WriteMessage(output, msg)
 	}
 
 	s.log.Printf("Channel processing finished. Exiting")
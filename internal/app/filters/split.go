@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"strconv"
@@ -14,29 +15,37 @@ type SplitFilter struct {
 
 	Delimiter string
 	Prefix    string
-	log       log.Logger
+	log       *log.Logger
 }
 
-func NewSplitFilter(options map[string]string, logger log.Logger) (f *SplitFilter, err error) {
-	f = &SplitFilter{}
+// SplitOptions - options of split filter
+type SplitOptions struct {
+	Delimiter string `hcl:"delimiter"`
+	Prefix    string `hcl:"prefix"`
+	Field     string `hcl:"field,optional"`
+}
 
-	if _, ok := options["delimiter"]; !ok {
-		logger.Print(options)
-		return f, errors.New("no delimiter specified")
-	}
+func init() {
+	registry.RegisterFilter("split", registry.NewFilterFactory(SplitOptions{Field: "content"}, NewSplitFilter), registry.Schema{
+		Description: "Split field by delimiter to prefixed fields",
+		Options: []registry.Option{
+			{Name: "delimiter", Description: "field delimiter", Required: true},
+			{Name: "prefix", Description: "prefix of result fields, field number is appended to it", Required: true},
+			{Name: "field", Description: "source field", Default: "content"},
+		},
+	})
+}
 
-	if _, ok := options["prefix"]; !ok {
-		return f, errors.New("no prefix specified")
-	}
+func NewSplitFilter(options SplitOptions, logger *log.Logger) (f *SplitFilter, err error) {
+	f = &SplitFilter{}
 
-	if _, ok := options["field"]; !ok {
-		f.Field = "content"
-	} else {
-		f.Field = options["field"]
+	if options.Delimiter == "" {
+		return f, errors.New("no delimiter specified")
 	}
 
-	f.Delimiter = options["delimiter"]
-	f.Prefix = options["prefix"]
+	f.Field = options.Field
+	f.Delimiter = options.Delimiter
+	f.Prefix = options.Prefix
 	f.log = logger
 
 	return f, nil
@@ -49,7 +58,10 @@ func (f *SplitFilter) Proceed(ctx context.Context, input chan structs.Message, o
 	f.log.Printf("Split filter activated. Delimiter: %s, Prefix: %s, Field: %s", f.Delimiter, f.Prefix, f.Field)
 
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		if fieldData, ok := msg.Payload[f.Field]; ok {
 			splitData := strings.Split(fieldData, f.Delimiter)
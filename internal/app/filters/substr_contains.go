@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"strings"
@@ -16,39 +17,44 @@ type SubstrContainsFilter struct {
 	TargetValue string
 	Substring   string
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewSubstrContainsFilter(options map[string]string, logger log.Logger) (f *SubstrContainsFilter, err error) {
-	f = &SubstrContainsFilter{}
-
-	if _, ok := options["substring"]; !ok {
-		return f, errors.New("no substring specified")
-	}
+// SubstrContainsOptions - options of substr_contains filter
+type SubstrContainsOptions struct {
+	Substring   string `hcl:"substring"`
+	Action      string `hcl:"action"`
+	TargetField string `hcl:"target_field"`
+	TargetValue string `hcl:"target_value"`
+}
 
-	f.Substring = options["substring"]
+func init() {
+	registry.RegisterFilter("substr_contains", registry.NewFilterFactory(SubstrContainsOptions{}, NewSubstrContainsFilter), registry.Schema{
+		Description: "Set field when value contains substring",
+		Options: []registry.Option{
+			{Name: "substring", Description: "substring to search", Required: true},
+			{Name: "action", Description: "action for matching messages, only set is supported", Required: true},
+			{Name: "target_field", Description: "field to set", Required: true},
+			{Name: "target_value", Description: "value to set", Required: true},
+		},
+	})
+}
 
-	if action, ok := options["action"]; ok {
-		if action != "set" {
-			return nil, errors.New("unknown action: " + f.Action)
-		}
+func NewSubstrContainsFilter(options SubstrContainsOptions, logger *log.Logger) (f *SubstrContainsFilter, err error) {
+	f = &SubstrContainsFilter{}
 
-		f.Action = action
-	} else {
-		return nil, errors.New("no action passed")
-	}
+	f.Substring = options.Substring
 
-	if fieldName, ok := options["target_field"]; ok {
-		f.TargetField = fieldName
-	} else {
-		return nil, errors.New("no target field")
+	if options.Action != "set" {
+		return nil, errors.New("unknown action: " + options.Action)
 	}
+	f.Action = options.Action
 
-	if fieldValue, ok := options["target_value"]; ok && fieldValue != "" {
-		f.TargetValue = fieldValue
-	} else {
+	if options.TargetValue == "" {
 		return nil, errors.New("no target value")
 	}
+	f.TargetField = options.TargetField
+	f.TargetValue = options.TargetValue
 
 	f.log = logger
 
@@ -61,7 +67,10 @@ func NewSubstrContainsFilter(options map[string]string, logger log.Logger) (f *S
 func (f *SubstrContainsFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
 
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		// skip records without target field
 		if _, ok := msg.Payload[f.Field]; !ok {
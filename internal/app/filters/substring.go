@@ -3,6 +3,7 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"strconv"
@@ -14,36 +15,38 @@ type SubstringFilter struct {
 	Start  int
 	Length int
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewSubstringFilter(options map[string]string, logger log.Logger) (f *SubstringFilter, err error) {
-	f = &SubstringFilter{}
+// SubstringOptions - options of substring filter
+type SubstringOptions struct {
+	Start  int `hcl:"start,optional"`
+	Length int `hcl:"length"`
+}
 
-	if startValue, ok := options["start"]; ok {
-		start, err := strconv.Atoi(startValue)
-		if err != nil {
-			f.log.Print("Unable to parse start value for filter, got: " + startValue + ", should be int")
-			return nil, err
-		}
+func init() {
+	registry.RegisterFilter("substring", registry.NewFilterFactory(SubstringOptions{}, NewSubstringFilter), registry.Schema{
+		Description: "Cut substring of field value",
+		Options: []registry.Option{
+			{Name: "start", Description: "substring start", Default: "0"},
+			{Name: "length", Description: "substring length", Required: true},
+		},
+	})
+}
 
-		f.Start = start
-	} else {
-		f.Start = 0
-	}
+func NewSubstringFilter(options SubstringOptions, logger *log.Logger) (f *SubstringFilter, err error) {
+	f = &SubstringFilter{}
 
-	if lengthValue, ok := options["length"]; ok {
-		length, err := strconv.Atoi(lengthValue)
-		if err != nil {
-			return nil, errors.New("unable to parse length option, expected string, got: " + lengthValue)
-		}
+	if options.Start < 0 {
+		return nil, errors.New("incorrect start value: " + strconv.Itoa(options.Start) + ", should not be negative")
+	}
 
-		f.Length = length
-	} else {
-		f.log.Print("No length options for filter")
-		return nil, errors.New("no length in filter options")
+	if options.Length < 0 {
+		return nil, errors.New("incorrect length value: " + strconv.Itoa(options.Length) + ", should not be negative")
 	}
 
+	f.Start = options.Start
+	f.Length = options.Length
 	f.log = logger
 
 	return f, nil
@@ -56,7 +59,10 @@ func (f *SubstringFilter) Proceed(ctx context.Context, input chan structs.Messag
 	end := f.Start + f.Length
 
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		// skip records without target field
 		if _, ok := msg.Payload[f.Field]; !ok {
@@ -64,8 +70,20 @@ func (f *SubstringFilter) Proceed(ctx context.Context, input chan structs.Messag
 			continue
 		}
 
+		value := msg.Payload[f.Field]
+		if len(value) < f.Start {
+			f.Reject(msg, errors.New("field "+f.Field+" is shorter than substring start"))
+			continue
+		}
+
+		// shorter values are cut from start only
+		valueEnd := end
+		if len(value) < valueEnd {
+			valueEnd = len(value)
+		}
+
 		payload := msg.Payload
-		payload[f.Field] = payload[f.Field][f.Start:end]
+		payload[f.Field] = value[f.Start:valueEnd]
 		msg.Payload = payload
 
 		_ = f.WriteMessage(output, msg)
@@ -0,0 +1,147 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/expression"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
+	"log"
+	"strconv"
+)
+
+// TagRule - tags added and removed when condition matches, empty condition matches all messages
+type TagRule struct {
+	Condition string    `hcl:"condition,optional"`
+	Add       cty.Value `hcl:"add,optional"`
+	Remove    cty.Value `hcl:"remove,optional"`
+}
+
+// TagOptions - unconditional add/remove lists are applied before rules
+type TagOptions struct {
+	Add    cty.Value `hcl:"add,optional"`
+	Remove cty.Value `hcl:"remove,optional"`
+	Rule   []TagRule `hcl:"rule,block"`
+}
+
+type tagRule struct {
+	condition *expression.Expression
+	add       []string
+	remove    []string
+}
+
+type TagFilter struct {
+	BasicFilter
+
+	rules []tagRule
+
+	log *log.Logger
+}
+
+func init() {
+	registry.RegisterFilter("tag", registry.NewFilterFactory(TagOptions{}, NewTagFilter), registry.Schema{
+		Description: "Add and remove message tags",
+		Options: []registry.Option{
+			{Name: "add", Description: "list of tags added to every message, comma separated string is accepted"},
+			{Name: "remove", Description: "list of tags removed from every message, comma separated string is accepted"},
+			{Name: "rule", Description: "block with condition expression and add/remove lists, rules are applied in order"},
+		},
+	})
+}
+
+func NewTagFilter(options TagOptions, logger *log.Logger) (f *TagFilter, err error) {
+	f = &TagFilter{}
+
+	rules := options.Rule
+	if !options.Add.IsNull() || !options.Remove.IsNull() {
+		rules = append([]TagRule{{Add: options.Add, Remove: options.Remove}}, rules...)
+	}
+
+	for i, rule := range rules {
+		var compiled tagRule
+
+		if compiled.add, err = registry.StringList(rule.Add); err != nil {
+			return nil, errors.New("incorrect add of rule #" + strconv.Itoa(i) + ": " + err.Error())
+		}
+
+		if compiled.remove, err = registry.StringList(rule.Remove); err != nil {
+			return nil, errors.New("incorrect remove of rule #" + strconv.Itoa(i) + ": " + err.Error())
+		}
+
+		if len(compiled.add) == 0 && len(compiled.remove) == 0 {
+			continue
+		}
+
+		if rule.Condition != "" {
+			compiled.condition, err = expression.Compile(rule.Condition)
+			if err != nil {
+				return nil, errors.New("invalid condition of rule #" + strconv.Itoa(i) + ": " + err.Error())
+			}
+		}
+
+		f.rules = append(f.rules, compiled)
+	}
+
+	if len(f.rules) == 0 {
+		return nil, errors.New("no tags to add or remove")
+	}
+
+	f.log = logger
+
+	return f, nil
+}
+
+func (f *TagFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("Tag filter started. Total rules: %d", len(f.rules))
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		for _, rule := range f.rules {
+			if rule.condition != nil && !rule.condition.Match(msg) {
+				continue
+			}
+
+			msg.Tags = updateTags(msg.Tags, rule.add, rule.remove)
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+// updateTags - new tags list, source list could be shared with message copies, so it's not modified
+func updateTags(tags []string, add []string, remove []string) []string {
+	result := make([]string, 0, len(tags)+len(add))
+
+	for _, tag := range tags {
+		if !containsTag(remove, tag) && !containsTag(result, tag) {
+			result = append(result, tag)
+		}
+	}
+
+	for _, tag := range add {
+		if !containsTag(result, tag) {
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, v := range tags {
+		if v == tag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,43 +4,85 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/queues"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"net"
 	"strconv"
+	"time"
 )
 
 const tcpTeeDumpStreamSize = 1024
 
+// previous filter instance could still hold the port during configuration reload
+const tcpTeeListenRetries = 5
+
 type TcpTeeFilter struct {
 	BasicFilter
 
 	DumpStream chan structs.Message
 	Port       int
-	log        log.Logger
+	log        *log.Logger
+
+	// dump stream overflow policy, messages are dropped when clients are too slow
+	OverflowPolicy string
+	SampleRate     int
+	overflow       *queues.Overflow
+}
+
+// TcpTeeOptions - options of tcp_tee filter
+type TcpTeeOptions struct {
+	Port           int    `hcl:"port"`
+	Overflow       string `hcl:"overflow,optional"`
+	OverflowSample int    `hcl:"overflow_sample,optional"`
+}
+
+func init() {
+	defaults := TcpTeeOptions{Overflow: queues.OverflowDropNewest}
+
+	registry.RegisterFilter("tcp_tee", registry.NewFilterFactory(defaults, NewTcpTeeFilter), registry.Schema{
+		Description: "Stream copy of messages as JSON to TCP clients",
+		Options: []registry.Option{
+			{Name: "port", Description: "TCP port", Required: true},
+			{Name: "overflow", Description: "policy for slow clients: block, drop_newest, drop_oldest or sample", Default: "drop_newest"},
+			{Name: "overflow_sample", Description: "keep every N-th message with sample policy", Default: "10"},
+		},
+	})
 }
 
-func NewTcpTeeFilter(options map[string]string, logger log.Logger) (f *TcpTeeFilter, err error) {
+func NewTcpTeeFilter(options TcpTeeOptions, logger *log.Logger) (f *TcpTeeFilter, err error) {
 	f = &TcpTeeFilter{}
-	if _, ok := options["port"]; !ok {
-		return f, errors.New("no port specified")
-	}
 
-	f.Port, err = strconv.Atoi(options["port"])
-	if err != nil {
-		return f, errors.New("failed to setup TCP-TEE port, got: " + err.Error())
+	if options.Port < 0 || options.Port > 65535 {
+		return f, errors.New("incorrect port, not in 1 - 65535 range: " + strconv.Itoa(options.Port))
 	}
 
-	if f.Port < 0 || f.Port > 65535 {
-		return f, errors.New("incorrect port, not in 1 - 65535 range: " + options["port"])
+	if options.OverflowSample < 0 {
+		return f, errors.New("incorrect overflow_sample value: " + strconv.Itoa(options.OverflowSample))
 	}
 
+	f.Port = options.Port
+	f.OverflowPolicy = options.Overflow
+	f.SampleRate = options.OverflowSample
+
 	f.log = logger
 	f.DumpStream = make(chan structs.Message, tcpTeeDumpStreamSize)
 
 	return f, nil
 }
 
+// Init - overflow policy is created here, because it's labeled with filter and pipeline names
+func (f *TcpTeeFilter) Init() (err error) {
+	if err = f.BasicFilter.Init(); err != nil {
+		return err
+	}
+
+	f.overflow, err = queues.NewOverflow(f.OverflowPolicy, f.SampleRate, f.Pipeline, f.GetName())
+
+	return err
+}
+
 func (f *TcpTeeFilter) IsThreadSafe() bool {
 	return false
 }
@@ -50,14 +92,20 @@ func (f *TcpTeeFilter) IsThreadSafe() bool {
  */
 func (f *TcpTeeFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
 	f.log.Print("TCP-TEE filter activated.")
-	go f.NewListener()
+	go f.NewListener(ctx)
 
-	for msg := range input {
-		output <- msg
-
-		if len(f.DumpStream) < tcpTeeDumpStreamSize-1 {
-			f.DumpStream <- msg
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
 		}
+
+		// dumped copy is not acknowledged, original message is delivered to output
+		dump := msg
+		dump.AckFunc = nil
+
+		_ = f.WriteMessage(output, msg)
+		f.overflow.Push(f.DumpStream, f.DumpStream, dump)
 	}
 
 	f.log.Printf("Channel processing finished. Exiting")
@@ -65,15 +113,28 @@ func (f *TcpTeeFilter) Proceed(ctx context.Context, input chan structs.Message,
 	return
 }
 
-func (f *TcpTeeFilter) NewListener() {
+func (f *TcpTeeFilter) NewListener(ctx context.Context) {
 	listenInterface := "0.0.0.0:" + strconv.Itoa(f.Port)
 
-	l, err := net.Listen("tcp", listenInterface)
-	if err != nil {
-		f.log.Fatalf("Failed to start listener. Got: %s", err.Error())
+	var l net.Listener
+	var err error
+	for try := 1; ; try += 1 {
+		l, err = net.Listen("tcp", listenInterface)
+		if err == nil {
+			break
+		}
+
+		if try == tcpTeeListenRetries {
+			f.log.Fatalf("Failed to start listener. Got: %s", err.Error())
+		}
+		time.Sleep(time.Second)
 	}
 
-	defer l.Close()
+	// release port when filter is stopped
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
 
 	f.log.Printf("Started new TCP-TEE on %s", listenInterface)
 
@@ -81,7 +142,12 @@ func (f *TcpTeeFilter) NewListener() {
 		// Listen for an incoming connection.
 		conn, err := l.Accept()
 		if err != nil {
-			f.log.Printf("Error accepting: ", err.Error())
+			if ctx.Err() != nil {
+				f.log.Printf("TCP-TEE on %s stopped", listenInterface)
+				return
+			}
+
+			f.log.Printf("Error accepting: %s", err.Error())
 			continue
 		}
 
@@ -3,11 +3,19 @@ package filters
 import (
 	"context"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
 	"time"
 )
 
+const (
+	// replace incorrect time with current one
+	timeFormatOnErrorCurrentTime = "current_time"
+	// send messages with incorrect time to dead letter queue
+	timeFormatOnErrorDeadLetter = "dead_letter"
+)
+
 type TimeFormatFilter struct {
 	BasicFilter
 
@@ -18,44 +26,54 @@ type TimeFormatFilter struct {
 
 	OnError string
 
-	log log.Logger
+	log *log.Logger
+}
+
+// TimeFormatOptions - options of time_format filter
+type TimeFormatOptions struct {
+	SourceFormat string `hcl:"source_format"`
+	TargetFormat string `hcl:"target_format"`
+	TargetField  string `hcl:"target_field,optional"`
+	Timezone     string `hcl:"timezone,optional"`
+	OnError      string `hcl:"on_error,optional"`
 }
 
-func NewTimeFormatFilter(options map[string]string, logger log.Logger) (t *TimeFormatFilter, err error) {
+func init() {
+	defaults := TimeFormatOptions{Timezone: "UTC", OnError: timeFormatOnErrorCurrentTime}
+
+	registry.RegisterFilter("time_format", registry.NewFilterFactory(defaults, NewTimeFormatFilter), registry.Schema{
+		Description: "Convert time field to another format",
+		Options: []registry.Option{
+			{Name: "source_format", Description: "Go layout of source time", Required: true},
+			{Name: "target_format", Description: "Go layout of result time", Required: true},
+			{Name: "target_field", Description: "field for result, source field by default"},
+			{Name: "timezone", Description: "timezone of source time", Default: "UTC"},
+			{Name: "on_error", Description: "current_time - use current time, dead_letter - reject message", Default: "current_time"},
+		},
+	})
+}
+
+func NewTimeFormatFilter(options TimeFormatOptions, logger *log.Logger) (t *TimeFormatFilter, err error) {
 	t = &TimeFormatFilter{}
 	t.log = logger
 
-	if sourceFormat, ok := options["source_format"]; ok {
-		t.SourceFormat = sourceFormat
-	} else {
-		return nil, errors.New("no source format")
-	}
-
-	if targetFormat, ok := options["target_format"]; ok {
-		t.TargetFormat = targetFormat
-	} else {
-		return nil, errors.New("no target format")
-	}
+	t.SourceFormat = options.SourceFormat
+	t.TargetFormat = options.TargetFormat
+	// empty target field is replaced with source field on start
+	t.TargetField = options.TargetField
 
-	if targetField, ok := options["target_field"]; ok {
-		t.TargetField = targetField
-	} else {
-		t.TargetField = t.Field
+	t.Timezone, err = time.LoadLocation(options.Timezone)
+	if err != nil {
+		return nil, err
 	}
 
-	if timezone, ok := options["timezone"]; ok {
-		location, err := time.LoadLocation(timezone)
-		if err != nil {
-			return nil, err
-		}
-
-		t.Timezone = location
-	} else {
-		t.Timezone, _ = time.LoadLocation("")
+	switch options.OnError {
+	case timeFormatOnErrorCurrentTime, timeFormatOnErrorDeadLetter:
+		t.OnError = options.OnError
+	default:
+		return nil, errors.New("unknown on_error value: " + options.OnError + ", should be current_time or dead_letter")
 	}
 
-	t.OnError = "current_time"
-
 	return t, nil
 }
 
@@ -68,7 +86,10 @@ func (t *TimeFormatFilter) Proceed(ctx context.Context, input chan structs.Messa
 	t.log.Printf("Converting %s (%s) to %s (%s)", t.SourceFormat, t.Field, t.TargetFormat, t.TargetField)
 
 	for ctx.Err() == nil {
-		msg, _ := t.ReadMessage(input)
+		msg, ok := t.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		if _, ok := msg.Payload[t.Field]; !ok {
 			_ = t.WriteMessage(output, msg)
@@ -81,9 +102,12 @@ func (t *TimeFormatFilter) Proceed(ctx context.Context, input chan structs.Messa
 				t.log.Print("Incorrect datetime: " + msg.Payload[t.Field] + ", error: " + err.Error())
 			}
 
-			if t.OnError == "current_time" {
-				date = time.Now()
+			if t.OnError == timeFormatOnErrorDeadLetter {
+				t.Reject(msg, err)
+				continue
 			}
+
+			date = time.Now()
 		}
 
 		date = date.In(t.Timezone)
@@ -0,0 +1,409 @@
+package filters
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	userAgentDefaultPrefix    = "ua_"
+	userAgentDefaultCacheSize = 10000
+	userAgentOther            = "Other"
+	userAgentSpider           = "Spider"
+)
+
+// userAgentFields - output fields, all of them are written by default
+var userAgentFields = []string{
+	"browser", "browser_version", "browser_major",
+	"os", "os_version", "os_major",
+	"device", "device_brand", "device_model", "device_type", "bot",
+}
+
+// desktop operating systems, Windows Phone and Windows Mobile are matched before
+var userAgentDesktopOs = []string{"Windows", "Mac OS X", "Linux", "Ubuntu", "Fedora", "Debian", "Chrome OS", "FreeBSD", "OpenBSD", "NetBSD", "Solaris"}
+
+// UserAgentOptions - options of user_agent filter, parsed field is set by filter field attribute
+type UserAgentOptions struct {
+	Database  string    `hcl:"database"`
+	Fields    cty.Value `hcl:"fields,optional"`
+	Prefix    string    `hcl:"prefix,optional"`
+	CacheSize int       `hcl:"cache_size,optional"`
+}
+
+// userAgentDatabase - regexes.yaml of uap-core
+type userAgentDatabase struct {
+	UserAgentParsers []userAgentRule `yaml:"user_agent_parsers"`
+	OsParsers        []userAgentRule `yaml:"os_parsers"`
+	DeviceParsers    []userAgentRule `yaml:"device_parsers"`
+}
+
+type userAgentRule struct {
+	Regex     string `yaml:"regex"`
+	RegexFlag string `yaml:"regex_flag"`
+
+	FamilyReplacement string `yaml:"family_replacement"`
+	V1Replacement     string `yaml:"v1_replacement"`
+	V2Replacement     string `yaml:"v2_replacement"`
+	V3Replacement     string `yaml:"v3_replacement"`
+
+	OsReplacement   string `yaml:"os_replacement"`
+	OsV1Replacement string `yaml:"os_v1_replacement"`
+	OsV2Replacement string `yaml:"os_v2_replacement"`
+	OsV3Replacement string `yaml:"os_v3_replacement"`
+	OsV4Replacement string `yaml:"os_v4_replacement"`
+
+	DeviceReplacement string `yaml:"device_replacement"`
+	BrandReplacement  string `yaml:"brand_replacement"`
+	ModelReplacement  string `yaml:"model_replacement"`
+}
+
+/**
+ * Compiled parser rule. Every result value is set by replacement with $1..$9
+ * group references or by default group, 0 means no default
+ */
+type userAgentParser struct {
+	expression   *regexp.Regexp
+	replacements []string
+	groups       []int
+}
+
+// userAgentInfo - parsed user agent, versions are joined with dots
+type userAgentInfo struct {
+	values map[string]string
+}
+
+/**
+ * User agent filter parses browser, OS and device with uap-core regexes database.
+ * Parsers of every kind are tried in order and the first matching one is used.
+ * Device type is guessed: bot for spiders, tablet, desktop for desktop OS, mobile
+ * for other known devices. Results are cached by user agent string
+ */
+type UserAgentFilter struct {
+	BasicFilter
+
+	browsers []userAgentParser
+	systems  []userAgentParser
+	devices  []userAgentParser
+
+	fields []string
+	prefix string
+	cache  *userAgentCache
+
+	log *log.Logger
+}
+
+func init() {
+	defaults := UserAgentOptions{Prefix: userAgentDefaultPrefix, CacheSize: userAgentDefaultCacheSize}
+
+	registry.RegisterFilter("user_agent", registry.NewFilterFactory(defaults, NewUserAgentFilter), registry.Schema{
+		Description: "Parse browser, OS and device of user agent with uap-core regexes",
+		Options: []registry.Option{
+			{Name: "database", Description: "path to uap-core regexes.yaml", Required: true, File: true},
+			{Name: "fields", Description: "list of output fields: " + strings.Join(userAgentFields, ", "), Default: "all"},
+			{Name: "prefix", Description: "prefix of output fields", Default: userAgentDefaultPrefix},
+			{Name: "cache_size", Description: "number of cached user agents, 0 disables cache", Default: strconv.Itoa(userAgentDefaultCacheSize)},
+		},
+	})
+}
+
+func NewUserAgentFilter(options UserAgentOptions, logger *log.Logger) (f *UserAgentFilter, err error) {
+	f = &UserAgentFilter{prefix: options.Prefix}
+	f.log = logger
+
+	if f.fields, err = registry.StringList(options.Fields); err != nil {
+		return nil, errors.New("incorrect fields: " + err.Error())
+	}
+
+	if len(f.fields) == 0 {
+		f.fields = userAgentFields
+	}
+
+	for _, field := range f.fields {
+		if !containsTag(userAgentFields, field) {
+			return nil, errors.New("unknown field " + field + ", should be one of " + strings.Join(userAgentFields, ", "))
+		}
+	}
+
+	if options.CacheSize < 0 {
+		return nil, errors.New("incorrect cache_size: " + strconv.Itoa(options.CacheSize))
+	}
+
+	if options.CacheSize > 0 {
+		f.cache = newUserAgentCache(options.CacheSize)
+	}
+
+	content, err := os.ReadFile(options.Database)
+	if err != nil {
+		return nil, errors.New("failed to read database: " + err.Error())
+	}
+
+	var database userAgentDatabase
+	if err := yaml.Unmarshal(content, &database); err != nil {
+		return nil, errors.New("failed to parse database: " + err.Error())
+	}
+
+	for _, rule := range database.UserAgentParsers {
+		f.browsers = f.appendParser(f.browsers, rule, []string{rule.FamilyReplacement, rule.V1Replacement, rule.V2Replacement, rule.V3Replacement}, []int{1, 2, 3, 4})
+	}
+
+	for _, rule := range database.OsParsers {
+		f.systems = f.appendParser(f.systems, rule, []string{rule.OsReplacement, rule.OsV1Replacement, rule.OsV2Replacement, rule.OsV3Replacement, rule.OsV4Replacement}, []int{1, 2, 3, 4, 5})
+	}
+
+	for _, rule := range database.DeviceParsers {
+		f.devices = f.appendParser(f.devices, rule, []string{rule.DeviceReplacement, rule.BrandReplacement, rule.ModelReplacement}, []int{1, 0, 1})
+	}
+
+	if len(f.browsers)+len(f.systems)+len(f.devices) == 0 {
+		return nil, errors.New("no parsers in database " + options.Database)
+	}
+
+	f.log.Printf("Loaded user agent database %s, browsers: %d, systems: %d, devices: %d",
+		options.Database, len(f.browsers), len(f.systems), len(f.devices))
+
+	return f, nil
+}
+
+// appendParser - compile rule, rules which are not supported by Go regexp syntax are skipped
+func (f *UserAgentFilter) appendParser(parsers []userAgentParser, rule userAgentRule, replacements []string, groups []int) []userAgentParser {
+	source := rule.Regex
+	if rule.RegexFlag == "i" {
+		source = "(?i)" + source
+	}
+
+	expression, err := regexp.Compile(source)
+	if err != nil {
+		f.log.Printf("Skipping user agent regexp %s: %s", rule.Regex, err.Error())
+		return parsers
+	}
+
+	return append(parsers, userAgentParser{expression: expression, replacements: replacements, groups: groups})
+}
+
+func (f *UserAgentFilter) Proceed(ctx context.Context, input chan structs.Message, output chan structs.Message) (err error) {
+	f.log.Printf("User agent filter started. Field: %s, fields: %v", f.Field, f.fields)
+
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		userAgent, ok := msg.Payload[f.Field]
+		if !ok || userAgent == "" {
+			_ = f.WriteMessage(output, msg)
+			continue
+		}
+
+		var info *userAgentInfo
+		if f.cache != nil {
+			info = f.cache.get(userAgent)
+		}
+
+		if info == nil {
+			info = f.parse(userAgent)
+			if f.cache != nil {
+				f.cache.add(userAgent, info)
+			}
+		}
+
+		for _, field := range f.fields {
+			msg.Payload[f.prefix+field] = info.values[field]
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	f.log.Printf("Channel processing finished. Exiting")
+
+	return
+}
+
+func (f *UserAgentFilter) parse(userAgent string) *userAgentInfo {
+	browser := userAgentMatch(f.browsers, userAgent)
+	system := userAgentMatch(f.systems, userAgent)
+	device := userAgentMatch(f.devices, userAgent)
+
+	info := &userAgentInfo{values: map[string]string{
+		"browser":         userAgentFamily(browser),
+		"browser_version": userAgentVersion(browser),
+		"browser_major":   userAgentPart(browser, 1),
+		"os":              userAgentFamily(system),
+		"os_version":      userAgentVersion(system),
+		"os_major":        userAgentPart(system, 1),
+		"device":          userAgentFamily(device),
+		"device_brand":    userAgentPart(device, 1),
+		"device_model":    userAgentPart(device, 2),
+	}}
+
+	bot := info.values["device"] == userAgentSpider
+	info.values["bot"] = strconv.FormatBool(bot)
+	info.values["device_type"] = userAgentDeviceType(info.values, bot)
+
+	return info
+}
+
+// userAgentMatch - values of the first matching parser, nil when nothing matched
+func userAgentMatch(parsers []userAgentParser, userAgent string) []string {
+	for _, p := range parsers {
+		match := p.expression.FindStringSubmatch(userAgent)
+		if match == nil {
+			continue
+		}
+
+		values := make([]string, len(p.replacements))
+		for i, replacement := range p.replacements {
+			switch {
+			case replacement != "":
+				values[i] = userAgentReplace(replacement, match)
+			case p.groups[i] > 0 && p.groups[i] < len(match):
+				values[i] = match[p.groups[i]]
+			}
+
+			values[i] = strings.TrimSpace(values[i])
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// userAgentReplace - substitute $1..$9 references with groups, missing groups are empty
+func userAgentReplace(replacement string, match []string) string {
+	if strings.IndexByte(replacement, '$') < 0 {
+		return replacement
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(replacement); i += 1 {
+		if replacement[i] == '$' && i+1 < len(replacement) && replacement[i+1] >= '1' && replacement[i+1] <= '9' {
+			if group := int(replacement[i+1] - '0'); group < len(match) {
+				result.WriteString(match[group])
+			}
+			i += 1
+			continue
+		}
+
+		result.WriteByte(replacement[i])
+	}
+
+	return result.String()
+}
+
+func userAgentFamily(values []string) string {
+	if len(values) == 0 || values[0] == "" {
+		return userAgentOther
+	}
+
+	return values[0]
+}
+
+func userAgentPart(values []string, i int) string {
+	if i >= len(values) {
+		return ""
+	}
+
+	return values[i]
+}
+
+// userAgentVersion - version parts joined up to the first empty one
+func userAgentVersion(values []string) string {
+	var parts []string
+	for i := 1; i < len(values) && values[i] != ""; i += 1 {
+		parts = append(parts, values[i])
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func userAgentDeviceType(values map[string]string, bot bool) string {
+	device := values["device"]
+	system := values["os"]
+
+	switch {
+	case bot:
+		return "bot"
+	case strings.Contains(device, "iPad") || strings.Contains(device, "Tablet") || strings.Contains(device, "Kindle"):
+		return "tablet"
+	case strings.HasPrefix(system, "Windows Phone") || strings.HasPrefix(system, "Windows Mobile"):
+		return "mobile"
+	}
+
+	for _, v := range userAgentDesktopOs {
+		if strings.HasPrefix(system, v) {
+			return "desktop"
+		}
+	}
+
+	if device != userAgentOther || system == "Android" || system == "iOS" {
+		return "mobile"
+	}
+
+	return "other"
+}
+
+/**
+ * LRU cache of parsed user agents, shared by filter threads. Number of unique
+ * user agents is low, so most of messages are not parsed
+ */
+type userAgentCache struct {
+	sync.Mutex
+
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type userAgentCacheItem struct {
+	key  string
+	info *userAgentInfo
+}
+
+func newUserAgentCache(size int) *userAgentCache {
+	return &userAgentCache{size: size, items: make(map[string]*list.Element, size), order: list.New()}
+}
+
+func (c *userAgentCache) get(key string) *userAgentInfo {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+
+	c.order.MoveToFront(element)
+
+	return element.Value.(*userAgentCacheItem).info
+}
+
+func (c *userAgentCache) add(key string, info *userAgentInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.order.MoveToFront(element)
+		element.Value.(*userAgentCacheItem).info = info
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&userAgentCacheItem{key: key, info: info})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*userAgentCacheItem).key)
+	}
+}
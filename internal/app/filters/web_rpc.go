@@ -6,7 +6,9 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -37,43 +39,62 @@ type WebRpcFilter struct {
 	Cache  map[string]RpcReply
 	Mutex  sync.RWMutex
 
-	log log.Logger
+	log *log.Logger
 }
 
-func NewWebRpcFilter(options map[string]string, logger log.Logger) (f *WebRpcFilter, err error) {
+// WebRpcOptions - options of web_rpc filter
+type WebRpcOptions struct {
+	Url    string    `hcl:"url"`
+	Fields cty.Value `hcl:"fields"`
+	Size   int       `hcl:"size,optional"`
+	OnFail string    `hcl:"on_fail,optional"`
+}
+
+func init() {
+	defaults := WebRpcOptions{Size: 2048, OnFail: "retry"}
+
+	registry.RegisterFilter("web_rpc", registry.NewFilterFactory(defaults, NewWebRpcFilter), registry.Schema{
+		Description: "Enrich messages with fields returned by HTTP service",
+		Options: []registry.Option{
+			{Name: "url", Description: "RPC service URL", Required: true},
+			{Name: "fields", Description: "list of fields sent to service, comma separated string is accepted", Required: true},
+			{Name: "size", Description: "cache size", Default: "2048"},
+			{Name: "on_fail", Description: "retry or skip failed requests", Default: "retry"},
+		},
+	})
+}
+
+func NewWebRpcFilter(options WebRpcOptions, logger *log.Logger) (f *WebRpcFilter, err error) {
 	f = &WebRpcFilter{}
 
-	if _, ok := options["url"]; !ok {
+	if options.Url == "" {
 		return f, errors.New("no RPC url provided")
 	}
+	f.Url = options.Url
+
+	f.Fields, err = registry.StringList(options.Fields)
+	if err != nil {
+		return f, errors.New("incorrect fields: " + err.Error())
+	}
 
-	f.Url = options["url"]
-	if _, ok := options["fields"]; !ok {
+	if len(f.Fields) == 0 {
 		return f, errors.New("no fields specified")
 	}
 
-	if _, ok := options["size"]; ok {
-		f.Size, err = strconv.Atoi(options["size"])
-		if err != nil {
-			f.Size = 2048
-		}
-	} else {
-		f.Size = 2048
+	if options.Size <= 0 {
+		return f, errors.New("incorrect cache size: " + strconv.Itoa(options.Size))
 	}
+	f.Size = options.Size
 
-	if _, ok := options["on_fail"]; ok {
-		if options["on_fail"] == "retry" {
-			f.OnFail = OnFailRetry
-		} else if options["on_fail"] == "skip" {
-			f.OnFail = OnFailSkip
-		} else {
-			return nil, errors.New("unknown on_fail mode: " + options["on_fail"] + ", should be skip or retry")
-		}
-	} else {
+	switch options.OnFail {
+	case "retry":
 		f.OnFail = OnFailRetry
+	case "skip":
+		f.OnFail = OnFailSkip
+	default:
+		return nil, errors.New("unknown on_fail mode: " + options.OnFail + ", should be skip or retry")
 	}
 
-	f.Fields = strings.Split(options["fields"], ",")
 	f.Mutex = sync.RWMutex{}
 
 	f.log = logger
@@ -87,7 +108,10 @@ func (f *WebRpcFilter) Proceed(ctx context.Context, input chan structs.Message,
 
 	i := 0
 	for ctx.Err() == nil {
-		msg, _ := f.ReadMessage(input)
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
 		if i == f.ServiceInterval {
 			f.log.Printf("doing service works. Total cache size: %d", len(f.Cache))
@@ -9,14 +9,14 @@ import (
 )
 
 type HttpService struct {
-	logger    log.Logger
-	Pipelines []*Pipeline
+	logger     *log.Logger
+	Supervisor *Supervisor
 }
 
-func NewHttp(logger log.Logger, pipelines []*Pipeline) (hs *HttpService, err error) {
+func NewHttp(logger *log.Logger, supervisor *Supervisor) (hs *HttpService, err error) {
 	hs = &HttpService{}
 	hs.logger = logger
-	hs.Pipelines = pipelines
+	hs.Supervisor = supervisor
 
 	return hs, err
 }
@@ -28,6 +28,7 @@ func (hs HttpService) Serve(listen string) {
 	//router.HandleFunc("/", indexPageg
 	router.HandleFunc("/status", hs.getStatus).Methods("GET") //curl -X GET "http://localhost:10200/regions"
 	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/reload", hs.reload).Methods("POST")
 
 	err := http.ListenAndServe(listen, router)
 	if err != nil {
@@ -52,8 +53,8 @@ func (hs HttpService) getStatus(w http.ResponseWriter, r *http.Request) {
 	status := StatusReply{}
 
 	var pipelineStatuses []PipelineStatus
-	for i := range hs.Pipelines {
-		status := hs.Pipelines[i].GetStatus()
+	for _, pipeline := range hs.Supervisor.GetPipelines() {
+		status := pipeline.GetStatus()
 
 		pipelineStatuses = append(pipelineStatuses, status)
 	}
@@ -62,9 +63,29 @@ func (hs HttpService) getStatus(w http.ResponseWriter, r *http.Request) {
 	hs.renderOk(w, status)
 }
 
+type ReloadReply struct {
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// re-read configuration, current pipelines keep running when new configuration is invalid
+func (hs HttpService) reload(w http.ResponseWriter, r *http.Request) {
+	hs.log(r, "configuration reload requested")
+
+	if err := hs.Supervisor.Reload(); err != nil {
+		hs.log(r, "configuration reload failed: "+err.Error())
+
+		w.WriteHeader(http.StatusBadRequest)
+		hs.renderOk(w, ReloadReply{Status: "failed", Error: err.Error()})
+		return
+	}
+
+	hs.renderOk(w, ReloadReply{Status: "ok"})
+}
+
 func (hs *HttpService) renderOk(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(data)
+	json.NewEncoder(RedactWriter(w)).Encode(data)
 }
 
 func renderError(w http.ResponseWriter, msg string) {
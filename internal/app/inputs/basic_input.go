@@ -7,7 +7,8 @@ import (
 )
 
 type BasicInput struct {
-	Name string
+	Name     string
+	Pipeline string
 }
 
 var generatedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -15,7 +16,7 @@ var generatedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "input",
 	Name:      "generated",
 	Help:      "Total number of output messages",
-}, []string{"input"})
+}, []string{"pipeline", "input"})
 
 var generatedRegisterOnce = sync.Once{}
 
@@ -44,10 +45,14 @@ func (bi *BasicInput) GetName() string {
 	return bi.Name
 }
 
+func (bi *BasicInput) SetPipeline(pipelineName string) {
+	bi.Pipeline = pipelineName
+}
+
 func (bi *BasicInput) WriteMessage(output chan structs.Message, msg structs.Message) error {
 	output <- msg
 
-	generatedMetrics.WithLabelValues(bi.GetName()).Inc()
+	generatedMetrics.WithLabelValues(bi.Pipeline, bi.GetName()).Inc()
 
 	return nil
 }
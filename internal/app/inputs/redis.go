@@ -3,15 +3,17 @@ package inputs
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"github.com/go-redis/redis"
+	"github.com/zclconf/go-cty/cty"
 	"log"
 	//"encoding/json"
 	"errors"
 	"io"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -27,7 +29,7 @@ const (
 type RedisInput struct {
 	BasicInput
 
-	log log.Logger
+	log *log.Logger
 
 	Inputs      []Connections
 	Key         string
@@ -41,56 +43,69 @@ type Connections struct {
 	Addr string
 }
 
-func NewRedisInput(options map[string]string, logger log.Logger) (o *RedisInput, err error) {
+// RedisInputOptions - options of redis input
+type RedisInputOptions struct {
+	Servers     cty.Value `hcl:"servers"`
+	Key         string    `hcl:"key,optional"`
+	Mode        string    `hcl:"mode,optional"`
+	Trim        bool      `hcl:"trim,optional"`
+	Batch       int       `hcl:"batch,optional"`
+	Compression bool      `hcl:"compression,optional"`
+}
+
+func init() {
+	defaults := RedisInputOptions{Key: DEFAULTKEY, Mode: "pop", Trim: true, Batch: defaultBatchSize}
+
+	registry.RegisterInput("redis", registry.NewInputFactory(defaults, NewRedisInput), registry.Schema{
+		Description: "Read messages from redis lists filled by redis output",
+		Options: []registry.Option{
+			{Name: "servers", Description: "list of redis addresses, comma separated string is accepted", Required: true},
+			{Name: "key", Description: "list key", Default: "logs"},
+			{Name: "mode", Description: "pop - read with LPOP, range - read with LRANGE and LTRIM, only one thread per key", Default: "pop"},
+			{Name: "trim", Description: "LTRIM list after LRANGE in range mode", Default: "true"},
+			{Name: "batch", Description: "number of items fetched at once", Default: "10000"},
+			{Name: "compression", Description: "items are compressed batches of messages", Default: "false"},
+		},
+	})
+}
+
+func NewRedisInput(options RedisInputOptions, logger *log.Logger) (o *RedisInput, err error) {
 	logger.Printf("Initializing redis input")
 
 	o = &RedisInput{}
 	o.log = logger
 
-	if _, ok := options["servers"]; !ok {
-		return nil, errors.New("no servers address")
+	servers, err := registry.StringList(options.Servers)
+	if err != nil {
+		return nil, errors.New("incorrect servers: " + err.Error())
 	}
 
-	if key, ok := options["key"]; ok {
-		o.Key = key
-	} else {
-		o.Key = DEFAULTKEY
+	if len(servers) == 0 {
+		return nil, errors.New("no servers address")
 	}
 
-	if fetchMode, ok := options["mode"]; ok {
-		if fetchMode == "range" {
-			o.FetchMode = fetchModeRangeTrim
-			o.log.Print("Attention! Fetch mode RANGE-TRIM activated. Make sure there is no additional threads for this key")
-		} else {
-			o.FetchMode = fetchModePop
-		}
-	} else {
+	o.Key = options.Key
+
+	switch options.Mode {
+	case "range":
+		o.FetchMode = fetchModeRangeTrim
+		o.log.Print("Attention! Fetch mode RANGE-TRIM activated. Make sure there is no additional threads for this key")
+	case "pop":
 		o.FetchMode = fetchModePop
+	default:
+		return nil, errors.New("unknown fetch mode: " + options.Mode + ", should be pop or range")
 	}
 
-	if trimOrig, ok := options["trim"]; ok {
-		o.Trim = trimOrig == "0" || trimOrig == "no" || trimOrig == "false"
-	} else {
-		o.Trim = true
-	}
+	o.Trim = options.Trim
 
-	if batchOrig, ok := options["batch"]; ok {
-		batch, err := strconv.Atoi(batchOrig)
-		if err != nil {
-			o.Batch = defaultBatchSize
-		}
-		o.Batch = batch
-	} else {
-		o.Batch = defaultBatchSize
+	if options.Batch <= 0 {
+		return nil, errors.New("incorrect batch size: " + strconv.Itoa(options.Batch))
 	}
+	o.Batch = options.Batch
 
-	if compression, ok := options["compression"]; ok {
-		o.Compression = o.IsActive(compression)
-	} else {
-		o.Compression = false
-	}
+	o.Compression = options.Compression
 
-	for _, v := range strings.Split(options["servers"], ",") {
+	for _, v := range servers {
 		c := Connections{Addr: v}
 		o.Inputs = append(o.Inputs, c)
 		o.log.Printf("Adding new connection: %s", v)
@@ -99,8 +114,8 @@ func NewRedisInput(options map[string]string, logger log.Logger) (o *RedisInput,
 	return o, nil
 }
 
-func (o *RedisInput) GetRedisConnection() (client *redis.Client, err error) {
-	for {
+func (o *RedisInput) GetRedisConnection(ctx context.Context) (client *redis.Client, err error) {
+	for ctx.Err() == nil {
 		client := redis.NewClient(&redis.Options{
 			Addr:        o.Inputs[0].Addr,
 			Password:    "",
@@ -118,27 +133,34 @@ func (o *RedisInput) GetRedisConnection() (client *redis.Client, err error) {
 		o.log.Printf("Redis connection established to %s", o.Inputs[0].Addr)
 		return client, err
 	}
+
+	return nil, ctx.Err()
 }
 
-func (o *RedisInput) AcceptTo(output chan structs.Message, counter chan int) (err error) {
+func (o *RedisInput) AcceptTo(ctx context.Context, output chan structs.Message, counter chan int) (err error) {
 	o.log.Printf("Started redis input. Input key: %s", o.Key)
 
 	if o.FetchMode == fetchModeRangeTrim {
-		return o.ProcessRangeTrim(output, counter)
+		return o.ProcessRangeTrim(ctx, output, counter)
 	} else {
-		return o.ProcessPop(output, counter)
+		return o.ProcessPop(ctx, output, counter)
 	}
 }
 
-func (o *RedisInput) ProcessRangeTrim(output chan structs.Message, counter chan int) (err error) {
-	o.log.Print("Started redis reader. Fetch mode LRANGE-LTRIM. Batch: %d, trim: %b", o.Batch, o.Trim)
-	client, err := o.GetRedisConnection()
+func (o *RedisInput) ProcessRangeTrim(ctx context.Context, output chan structs.Message, counter chan int) (err error) {
+	o.log.Printf("Started redis reader. Fetch mode LRANGE-LTRIM. Batch: %d, trim: %t", o.Batch, o.Trim)
+	client, err := o.GetRedisConnection(ctx)
+	if err != nil {
+		return err
+	}
 
-	for {
+	// batch is always processed completely, cancellation is checked only between
+	// batches, so trimmed items are never lost
+	for ctx.Err() == nil {
 		res := client.LRange(o.Key, 0, int64(o.Batch-1))
 		if err != nil {
 			o.log.Print("failed to connect. got: " + err.Error() + ", going to reconnect")
-			client, err = o.GetRedisConnection()
+			client, err = o.GetRedisConnection(ctx)
 			if err != nil {
 				time.Sleep(10 * time.Second)
 			}
@@ -218,9 +240,16 @@ func (o *RedisInput) ProcessRangeTrim(output chan structs.Message, counter chan
 
 		counter <- chunkProcessed
 	}
+
+	if client != nil {
+		client.Close()
+	}
+	o.log.Printf("Redis reader for %s stopped", o.Key)
+
+	return nil
 }
 
-func (o *RedisInput) ProcessPop(output chan structs.Message, counter chan int) (err error) {
+func (o *RedisInput) ProcessPop(ctx context.Context, output chan structs.Message, counter chan int) (err error) {
 	o.log.Print("Started redis reader. Fetch mode BLPOP")
 
 	/**
@@ -1,8 +1,10 @@
 package inputs
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"gopkg.in/mcuadros/go-syslog.v2"
 	"log"
@@ -13,7 +15,7 @@ import (
 type Syslog struct {
 	BasicInput
 
-	log       log.Logger
+	log       *log.Logger
 	Ip        string
 	QueueSize int
 	Port      int
@@ -25,34 +27,36 @@ const (
 	syslogDefaultPort      = 514
 )
 
-func NewSyslog(options map[string]string, logger log.Logger) (s *Syslog, err error) {
-	s = &Syslog{}
-	if _, ok := options["ip"]; !ok {
-		logger.Printf("No listen IP specified, using default: 0.0.0.0")
-		s.Ip = "0.0.0.0"
-	} else {
-		s.Ip = options["ip"]
-	}
+// SyslogOptions - options of syslog input
+type SyslogOptions struct {
+	Ip    string `hcl:"ip,optional"`
+	Port  int    `hcl:"port,optional"`
+	Queue int    `hcl:"queue,optional"`
+}
 
-	if queueSize, ok := options["queue"]; ok {
-		queueSizeReal, err := strconv.Atoi(queueSize)
-		if err != nil {
-			return nil, errors.New("incorrect syslog queue size: " + queueSize)
-		}
-		s.QueueSize = queueSizeReal
-	} else {
-		s.QueueSize = syslogDefaultQueueSize
+func init() {
+	defaults := SyslogOptions{Ip: "0.0.0.0", Port: syslogDefaultPort, Queue: syslogDefaultQueueSize}
+
+	registry.RegisterInput("syslog", registry.NewInputFactory(defaults, NewSyslog), registry.Schema{
+		Description: "Receive syslog messages over UDP",
+		Options: []registry.Option{
+			{Name: "ip", Description: "listen address", Default: "0.0.0.0"},
+			{Name: "port", Description: "UDP port", Default: "514"},
+			{Name: "queue", Description: "size of received packets queue", Default: "8192"},
+		},
+	})
+}
+
+func NewSyslog(options SyslogOptions, logger *log.Logger) (s *Syslog, err error) {
+	if options.Queue <= 0 {
+		return nil, errors.New("incorrect syslog queue size: " + strconv.Itoa(options.Queue))
 	}
 
-	if _, ok := options["port"]; !ok {
-		logger.Printf("No listen port specified, using default: 514")
-		s.Port = syslogDefaultPort
-	} else {
-		s.Port, err = strconv.Atoi(options["port"])
-		if err != nil {
-			return s, errors.New("Incorrect port number. Got: " + options["port"])
-		}
+	if options.Port <= 0 || options.Port > 65535 {
+		return nil, errors.New("Incorrect port number. Got: " + strconv.Itoa(options.Port))
 	}
+
+	s = &Syslog{Ip: options.Ip, Port: options.Port, QueueSize: options.Queue}
 	s.log = logger
 	s.log.Printf("Configured syslog on %s:%d", s.Ip, s.Port)
 
@@ -64,9 +68,9 @@ func (s *Syslog) IsMultiThread() bool {
 }
 
 /**
- * Accept messages and send them to channel
+ * Accept messages and send them to channel until context is cancelled
  */
-func (s *Syslog) AcceptTo(output chan structs.Message, counter chan int) (err error) {
+func (s *Syslog) AcceptTo(ctx context.Context, output chan structs.Message, counter chan int) (err error) {
 	s.log.Printf("Starting syslog acceptor on %s:%d, queue size: %d", s.Ip, s.Port, s.QueueSize)
 
 	channel := make(syslog.LogPartsChannel, s.QueueSize)
@@ -78,6 +82,16 @@ func (s *Syslog) AcceptTo(output chan structs.Message, counter chan int) (err er
 	server.ListenUDP(fmt.Sprintf("%s:%d", s.Ip, s.Port))
 	server.Boot()
 
+	// stop listening on cancel, messages which are already accepted will be
+	// delivered before channel is closed
+	go func() {
+		<-ctx.Done()
+		s.log.Printf("Stopping syslog acceptor on %s:%d", s.Ip, s.Port)
+		server.Kill()
+		server.Wait()
+		close(channel)
+	}()
+
 	i := 0
 
 	for logItem := range channel {
@@ -96,6 +110,12 @@ func (s *Syslog) AcceptTo(output chan structs.Message, counter chan int) (err er
 		_ = s.WriteMessage(output, msg)
 	}
 
+	if i > 0 {
+		counter <- i
+	}
+
+	s.log.Printf("Syslog acceptor on %s:%d finished", s.Ip, s.Port)
+
 	return
 }
 
@@ -1,15 +1,34 @@
 package outputs
 
 import (
+	"context"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/prometheus/client_golang/prometheus"
+	"log"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 type BasicOutput struct {
-	Debug bool
-	Name  string
+	Debug      bool
+	Name       string
+	Pipeline   string
+	DeadLetter chan structs.DeadLetter
+	// Logger - pipeline logger, used when message is rejected without dead letter queue
+	Logger *log.Logger
 }
 
+var rejectedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "outputs",
+	Name:      "rejected",
+	Help:      "Total number of messages sent to dead letter queue",
+}, []string{"pipeline", "output"})
+
+var outputRegisterOnce = sync.Once{}
+
 func (bo *BasicOutput) PrepareStringVariable(template string, variables map[string]string) (res string) {
 	reg := `\$\{(?P<variable>[A-Z\_]+)\}`
 	r := regexp.MustCompile(reg)
@@ -28,7 +47,21 @@ func (bo *BasicOutput) PrepareStringVariable(template string, variables map[stri
 	return res
 }
 
+// ReadMessage - read next message, ok is false when input is closed or context is cancelled
+func (bo *BasicOutput) ReadMessage(ctx context.Context, input chan structs.Message) (msg structs.Message, ok bool) {
+	select {
+	case msg, ok = <-input:
+	case <-ctx.Done():
+	}
+
+	return
+}
+
 func (bo *BasicOutput) Init() error {
+	outputRegisterOnce.Do(func() {
+		prometheus.MustRegister(rejectedMetrics)
+	})
+
 	return nil
 }
 
@@ -40,6 +73,39 @@ func (bo *BasicOutput) GetName() string {
 	return bo.Name
 }
 
+func (bo *BasicOutput) SetPipeline(pipelineName string) {
+	bo.Pipeline = pipelineName
+}
+
 func (bo *BasicOutput) SetDebug(debug bool) {
 	bo.Debug = debug
 }
+
+func (bo *BasicOutput) SetDeadLetter(deadLetter chan structs.DeadLetter) {
+	bo.DeadLetter = deadLetter
+}
+
+func (bo *BasicOutput) SetLogger(logger *log.Logger) {
+	bo.Logger = logger
+}
+
+/**
+ * Reject - send message which could not be delivered to dead letter queue. Without
+ * dead letter queue, like in dead letter output itself, message is logged and acknowledged
+ */
+func (bo *BasicOutput) Reject(msg structs.Message, err error) {
+	rejectedMetrics.WithLabelValues(bo.Pipeline, bo.GetName()).Inc()
+
+	if bo.DeadLetter == nil {
+		logger := bo.Logger
+		if logger == nil {
+			logger = log.Default()
+		}
+
+		logger.Printf("Output %s rejected message: %s", bo.GetName(), err.Error())
+		msg.Ack()
+		return
+	}
+
+	bo.DeadLetter <- structs.DeadLetter{Message: msg, Stage: bo.GetName(), Error: err.Error(), Time: time.Now()}
+}
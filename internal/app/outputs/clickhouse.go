@@ -1,10 +1,13 @@
 package outputs
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/ClickHouse/clickhouse-go"
 	"log"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
 	"time"
 	"strings"
 	"errors"
@@ -17,7 +20,7 @@ const ClickhouseTimeThreshold = 60
 type ClickhouseOutput struct {
 	BasicOutput
 
-	log log.Logger
+	log *log.Logger
 
 	Fields      []string
 	FieldsTypes []string
@@ -28,54 +31,78 @@ type ClickhouseOutput struct {
 	Table string
 }
 
-func NewClickhouseOutput(options map[string]string, logger log.Logger) (c *ClickhouseOutput, err error) {
+// ClickhouseOptions - options of clickhouse output
+type ClickhouseOptions struct {
+	Dsn       string    `hcl:"dsn"`
+	Table     string    `hcl:"table"`
+	Fields    cty.Value `hcl:"fields"`
+	Batch     int       `hcl:"batch,optional"`
+	Threshold string    `hcl:"threshold,optional"`
+}
+
+func init() {
+	defaults := ClickhouseOptions{Batch: 100, Threshold: strconv.Itoa(ClickhouseTimeThreshold)}
+
+	registry.RegisterOutput("clickhouse", registry.NewOutputFactory(defaults, NewClickhouseOutput), registry.Schema{
+		Description: "Insert messages to ClickHouse table",
+		Options: []registry.Option{
+			{Name: "dsn", Description: "ClickHouse DSN", Required: true},
+			{Name: "table", Description: "table name", Required: true},
+			{Name: "fields", Description: "list of field[:type] columns, comma separated string is accepted", Required: true},
+			{Name: "batch", Description: "insert batch size", Default: "100"},
+			{Name: "threshold", Description: "max time between inserts, seconds or duration like 1m", Default: "60"},
+		},
+	})
+}
+
+func NewClickhouseOutput(options ClickhouseOptions, logger *log.Logger) (c *ClickhouseOutput, err error) {
 	c = &ClickhouseOutput{}
 	c.log = logger
-	if fieldsList, ok := options["fields"]; ok {
-		for _, fieldName := range strings.Split(fieldsList, ",") {
-			info := strings.Split(fieldName, ":")
-			c.Fields = append(c.Fields, info[0])
-
-			if len(info) > 1 {
-				c.FieldsTypes = append(c.FieldsTypes, info[1])
-			} else {
-				c.FieldsTypes = append(c.FieldsTypes, "string")
-			}
-		}
-	} else {
+
+	fields, err := registry.StringList(options.Fields)
+	if err != nil {
+		return nil, errors.New("incorrect fields: " + err.Error())
+	}
+
+	if len(fields) == 0 {
 		return nil, errors.New("no fields available")
 	}
 
-	if dsn, ok := options["dsn"]; ok {
-		c.Dsn = dsn
-	} else {
+	for _, fieldName := range fields {
+		info := strings.Split(fieldName, ":")
+		c.Fields = append(c.Fields, info[0])
+
+		if len(info) > 1 {
+			c.FieldsTypes = append(c.FieldsTypes, info[1])
+		} else {
+			c.FieldsTypes = append(c.FieldsTypes, "string")
+		}
+	}
+
+	if options.Dsn == "" {
 		return nil, errors.New("no dsn available")
 	}
+	c.Dsn = options.Dsn
 
-	if table, ok := options["table"]; ok {
-		c.Table = table
-	} else {
+	if options.Table == "" {
 		return nil, errors.New("no table specified")
 	}
+	c.Table = options.Table
 
-	if batch, ok := options["batch"]; ok {
-		c.Batch, err = strconv.Atoi(batch)
-		if err != nil {
-			return nil, errors.New("incorrect clickhouse batch size: " + batch)
-		}
-	} else {
-		c.Batch = 100
+	if options.Batch <= 0 {
+		return nil, errors.New("incorrect clickhouse batch size: " + strconv.Itoa(options.Batch))
 	}
+	c.Batch = options.Batch
 
-	if threshold, ok := options["threshold"]; ok {
-		threshold, err := strconv.Atoi(threshold)
-		if err != nil {
-			c.Threshold = int64(ClickhouseTimeThreshold)
-		}
-		c.Threshold = int64(threshold)
-	} else {
-		c.Threshold = int64(ClickhouseTimeThreshold)
+	threshold, err := registry.ParseDuration(options.Threshold)
+	if err != nil {
+		return nil, errors.New("incorrect clickhouse threshold: " + err.Error())
+	}
+
+	if threshold < time.Second {
+		return nil, errors.New("incorrect clickhouse threshold: " + options.Threshold + ", should be at least 1 second")
 	}
+	c.Threshold = int64(threshold / time.Second)
 
 	c.log.Printf("Initialized ClickHouse to %s, batch: %d", c.Dsn, c.Batch)
 
@@ -96,11 +123,22 @@ func pingDsn(dsn string) (err error) {
 	return
 }
 
-func (c *ClickhouseOutput) delay(try int) {
-	time.Sleep(5 * time.Second)
+// delay - wait before next try, false is returned when output is stopped
+func (c *ClickhouseOutput) delay(ctx context.Context, try int) bool {
+	select {
+	case <-time.After(5 * time.Second):
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
-func (c *ClickhouseOutput) flushBufferTry(buffer []structs.Message, try int) error {
+/**
+ * Insert buffer in one transaction. Rows which could not be inserted are
+ * returned with their errors, they are rejected only when transaction is
+ * committed, so failed tries don't produce duplicates in dead letter queue
+ */
+func (c *ClickhouseOutput) flushBufferTry(buffer []structs.Message, try int) (rejected map[int]error, err error) {
 	var tx *sql.Tx
 	var stmt *sql.Stmt
 
@@ -109,13 +147,13 @@ func (c *ClickhouseOutput) flushBufferTry(buffer []structs.Message, try int) err
 
 	if err != nil {
 		c.log.Printf("try %d, failed to connect with server: %s", try, err.Error())
-		return err
+		return nil, err
 	}
 
 	err = connect.Ping()
 	if err != nil {
 		c.log.Printf("try %d, server is not responding, got: %s", try, err.Error())
-		return err
+		return nil, err
 	}
 
 	var placeholders []string
@@ -133,49 +171,74 @@ func (c *ClickhouseOutput) flushBufferTry(buffer []structs.Message, try int) err
 	tx, err = connect.Begin()
 	if err != nil {
 		c.log.Printf("try %d, failed to initialize transaction: %s", try, err.Error())
-		return err
+		return nil, err
 	}
 
 	stmt, err = tx.Prepare(query)
 	if err != nil {
 		c.log.Printf("try %d, failed to prepare statement: %s", try, err.Error())
-		return err
+		return nil, err
 	}
 
-	for _, msg := range buffer {
+	rejected = make(map[int]error)
+	for i, msg := range buffer {
 		arguments, err = c.PrepareArguments(msg.Payload)
 		if err != nil {
 			c.log.Printf("failed to proceed row. error: %s", err.Error())
+			rejected[i] = err
 			continue
 		}
 
 		if _, err := stmt.Exec(arguments...); err != nil {
-			c.log.Printf("failed to proceed row: %s, got: %s", arguments, err.Error())
+			c.log.Printf("failed to proceed row: %v, got: %s", arguments, err.Error())
+			rejected[i] = err
 			continue
 		}
 	}
 
 	err = tx.Commit()
 	if err == nil {
-		c.log.Printf("inserted %d rows, try: %d", len(buffer), try)
-		return nil
+		c.log.Printf("inserted %d rows, try: %d", len(buffer)-len(rejected), try)
+		return rejected, nil
 	}
 
 	c.log.Printf("failed to commit clickhouse transaction, try: %d, got: %s", try, err.Error())
-	return err
+	return nil, err
 }
 
-func (c *ClickhouseOutput) flushBuffer(buffer []structs.Message) error {
+/**
+ * Insert buffer, failed inserts are retried until output is stopped. Buffer is
+ * tried at least once, it's rejected when output is stopped before insert
+ */
+func (c *ClickhouseOutput) flushBuffer(ctx context.Context, buffer []structs.Message) error {
 	try := 0
 
 	for {
 		try += 1
 
-		err := c.flushBufferTry(buffer, try)
+		rejected, err := c.flushBufferTry(buffer, try)
 		if err != nil {
 			c.log.Print("failed to flush buffer: " + err.Error())
-			c.delay(try)
-			continue
+			if c.delay(ctx, try) {
+				continue
+			}
+
+			c.log.Printf("Output is stopped, rejecting %d items of buffer", len(buffer))
+			for _, msg := range buffer {
+				c.Reject(msg, err)
+			}
+
+			return err
+		}
+
+		// rejected messages are acknowledged by dead letter output
+		for i, msg := range buffer {
+			if err, ok := rejected[i]; ok {
+				c.Reject(msg, err)
+				continue
+			}
+
+			msg.Ack()
 		}
 
 		return nil
@@ -186,7 +249,7 @@ func (c *ClickhouseOutput) flushBuffer(buffer []structs.Message) error {
  * Read data to local buffer and flush it when it's filled or when
  * time threshold is reached
  */
-func (c *ClickhouseOutput) ReadFrom(input chan structs.Message,  runtimeOptions map[string]string, counter chan int) (err error) {
+func (c *ClickhouseOutput) ReadFrom(ctx context.Context, input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
 	i := 0
 
 	lastFill := time.Now().Unix()
@@ -194,7 +257,12 @@ func (c *ClickhouseOutput) ReadFrom(input chan structs.Message,  runtimeOptions
 
 	buffer := make([]structs.Message, c.Batch)
 
-	for msg := range input {
+	for ctx.Err() == nil {
+		msg, ok := c.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		buffer[i] = msg
 		i += 1
 
@@ -209,7 +277,7 @@ func (c *ClickhouseOutput) ReadFrom(input chan structs.Message,  runtimeOptions
 
 			c.log.Printf("Batch filled in %d seconds. Inserting %d items to database", diff, i)
 			lastFill = currentTime
-			c.flushBuffer(buffer[:i])
+			c.flushBuffer(ctx, buffer[:i])
 
 			counter <- i
 
@@ -217,6 +285,14 @@ func (c *ClickhouseOutput) ReadFrom(input chan structs.Message,  runtimeOptions
 		}
 	}
 
+	// input is closed or output is stopped, flush everything that is left in buffer
+	if i > 0 {
+		c.log.Printf("Flushing %d items left in buffer", i)
+		c.flushBuffer(ctx, buffer[:i])
+
+		counter <- i
+	}
+
 	c.log.Print("ClickHouse: channel finished. Exiting...")
 	return
 }
@@ -1,17 +1,28 @@
 package outputs
 
 import (
+	"context"
 	"log"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 )
 
 type NullOutput struct {
 	BasicOutput
 
-	Log log.Logger
+	Log *log.Logger
 }
 
-func NewNullOutput(options map[string]string, logger log.Logger) (s *NullOutput, err error) {
+// NullOptions - null output has no options
+type NullOptions struct{}
+
+func init() {
+	registry.RegisterOutput("null", registry.NewOutputFactory(NullOptions{}, NewNullOutput), registry.Schema{
+		Description: "Discard messages",
+	})
+}
+
+func NewNullOutput(options NullOptions, logger *log.Logger) (s *NullOutput, err error) {
 	logger.Printf("Initializing stdout output")
 
 	s = &NullOutput{}
@@ -19,9 +30,14 @@ func NewNullOutput(options map[string]string, logger log.Logger) (s *NullOutput,
 	return s, nil
 }
 
-func (s *NullOutput) ReadFrom(input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
-	for range input {
+func (s *NullOutput) ReadFrom(ctx context.Context, input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
+	for ctx.Err() == nil {
+		msg, ok := s.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
 
+		msg.Ack()
 	}
 
 	return
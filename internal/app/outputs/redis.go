@@ -1,13 +1,15 @@
 package outputs
 
 import (
+	"context"
 	"github.com/go-redis/redis"
 	"log"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
+	"github.com/zclconf/go-cty/cty"
 	"encoding/json"
 	"strconv"
 	"errors"
-	"strings"
 	"time"
 	"bytes"
 	"compress/gzip"
@@ -16,12 +18,13 @@ import (
 const (
 	redisDefaultBatchSize = 1000
 	redisDefaultKey       = "logs"
+	redisPushRetries      = 32
 )
 
 type RedisOutput struct {
 	BasicOutput
 
-	log log.Logger
+	log *log.Logger
 
 	OutputMode    string // available modes - sharding or replica
 	Outputs       []Connections
@@ -34,43 +37,60 @@ type Connections struct {
 	Addr string
 }
 
-func NewRedisOutput(options map[string]string, logger log.Logger) (o *RedisOutput, err error) {
-	logger.Printf("Initializing stat output")
+// RedisOutputOptions - options of redis output
+type RedisOutputOptions struct {
+	Servers       cty.Value `hcl:"servers"`
+	Key           string    `hcl:"key,optional"`
+	Batch         int       `hcl:"batch,optional"`
+	CompressBatch int       `hcl:"compress_batch,optional"`
+}
+
+func init() {
+	defaults := RedisOutputOptions{Key: redisDefaultKey, Batch: redisDefaultBatchSize}
+
+	registry.RegisterOutput("redis", registry.NewOutputFactory(defaults, NewRedisOutput), registry.Schema{
+		Description: "Push messages to redis lists",
+		Options: []registry.Option{
+			{Name: "servers", Description: "list of redis addresses, comma separated string is accepted", Required: true},
+			{Name: "key", Description: "list key", Default: "logs"},
+			{Name: "batch", Description: "number of messages pushed at once", Default: "1000"},
+			{Name: "compress_batch", Description: "compress batches of N messages, 0 disables compression", Default: "0"},
+		},
+	})
+}
+
+func NewRedisOutput(options RedisOutputOptions, logger *log.Logger) (o *RedisOutput, err error) {
+	logger.Printf("Initializing redis output")
 
 	o = &RedisOutput{}
 	o.log = logger
 
-	if batchSize, ok := options["batch"]; ok {
-		o.Batch, err = strconv.Atoi(batchSize)
-		if err != nil {
-			o.Batch = redisDefaultBatchSize
-		}
-	} else {
-		o.Batch = redisDefaultBatchSize
+	if options.Batch <= 0 {
+		return nil, errors.New("incorrect batch size: " + strconv.Itoa(options.Batch))
 	}
+	o.Batch = options.Batch
 
-	if _, ok := options["servers"]; !ok {
-		return nil, errors.New("no servers address")
+	servers, err := registry.StringList(options.Servers)
+	if err != nil {
+		return nil, errors.New("incorrect servers: " + err.Error())
 	}
 
-	if key, ok := options["key"]; ok {
-		o.Key = key
-	} else {
-		o.Key = redisDefaultKey
+	if len(servers) == 0 {
+		return nil, errors.New("no servers address")
 	}
 
-	if compressBatch, ok := options["compress_batch"]; ok {
-		o.CompressBatch, err = strconv.Atoi(compressBatch)
-		if err != nil {
-			return nil, errors.New("incorrect compress_batch value: " + compressBatch)
-		}
+	o.Key = options.Key
 
-		o.log.Printf("Initialized compress batch: %s", compressBatch)
-	} else {
-		o.CompressBatch = 0
+	if options.CompressBatch < 0 {
+		return nil, errors.New("incorrect compress_batch value: " + strconv.Itoa(options.CompressBatch))
 	}
+	o.CompressBatch = options.CompressBatch
 
-	for _, v := range strings.Split(options["servers"], ",") {
+	if o.CompressBatch > 0 {
+		o.log.Printf("Initialized compress batch: %d", o.CompressBatch)
+	}
+
+	for _, v := range servers {
 		c := Connections{Addr: v}
 		o.Outputs = append(o.Outputs, c)
 		o.log.Printf("Adding new connection: %s", v)
@@ -79,7 +99,7 @@ func NewRedisOutput(options map[string]string, logger log.Logger) (o *RedisOutpu
 	return o, nil
 }
 
-func (o *RedisOutput) ReadFrom(input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
+func (o *RedisOutput) ReadFrom(ctx context.Context, input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
 	keyName := o.PrepareStringVariable(o.Key, runtimeOptions)
 
 	o.log.Printf("Started redis output, batch: %d, output to %s, compress batch: %d", o.Batch, keyName, o.CompressBatch)
@@ -91,100 +111,180 @@ func (o *RedisOutput) ReadFrom(input chan structs.Message, runtimeOptions map[st
 		compressCache = make([]string, o.CompressBatch)
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     o.Outputs[0].Addr,
-		Password: "",
-		DB:       0,
-	})
+	// original messages of current batch, they are rejected when batch could not be pushed
+	var pending, compressPending []structs.Message
 
-	_, err = client.Ping().Result()
-	if err != nil {
-		o.log.Printf("Failed to ping server. Got: " + err.Error())
-	}
+	client := o.connect()
 
 	cachePos := 0
 	compressPos := 0
 
-messageCycle:
-	for msg := range input {
+	for ctx.Err() == nil {
+		msg, ok := o.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		info, err := json.Marshal(msg)
 		if err != nil {
 			o.log.Print("failed to encode to JSON: " + err.Error())
+			o.Reject(msg, err)
 			continue
 		}
 
 		if o.CompressBatch > 0 {
 			compressCache[compressPos] = string(info)
+			compressPending = append(compressPending, msg)
 			compressPos += 1
 
-
 			if compressPos == o.CompressBatch {
 				compressPos = 0
 
-				encodedData, err := json.Marshal(compressCache)
+				compressed, err := o.compress(compressCache)
 				if err != nil {
-					o.log.Printf("failed to encode compress data: " + err.Error())
-
+					o.log.Print("failed to compress: " + err.Error())
+					o.rejectAll(compressPending, err)
+					compressPending = compressPending[:0]
 					compressCache = make([]string, o.CompressBatch)
 					continue
 				}
 
-				var buf bytes.Buffer
-				gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
-
-				_, err = gz.Write(encodedData)
-				if err != nil {
-					o.log.Printf("failed to compress: " + err.Error())
-					compressCache = make([]string, o.CompressBatch)
-				}
-				gz.Flush()
-				gz.Close()
-
-				if o.Debug {
-					compression := 100.0 * float64(buf.Len()) / float64(len(encodedData))
-					o.log.Printf("data compressed %d => %d, compression %0.2f%%", len(encodedData), buf.Len(), compression)
-				}
-
-				cache[cachePos] = buf.Bytes()
+				cache[cachePos] = compressed
 				cachePos += 1
+				pending = append(pending, compressPending...)
+				compressPending = compressPending[:0]
 			}
 		} else {
 			cache[cachePos] = info
 			cachePos += 1
+			pending = append(pending, msg)
 		}
 
 		if cachePos == o.Batch {
 			cachePos = 0
+			client = o.push(ctx, client, keyName, cache, pending, counter)
+			pending = pending[:0]
+		}
+	}
 
-			for retry := 0; retry < 32; retry += 1 {
-				cmdResult := client.RPush(keyName, cache...)
+	// input is closed or output is stopped, flush partially filled batches
+	if compressPos > 0 {
+		compressed, err := o.compress(compressCache[:compressPos])
+		if err != nil {
+			o.log.Print("failed to compress: " + err.Error())
+			o.rejectAll(compressPending, err)
+		} else {
+			cache[cachePos] = compressed
+			cachePos += 1
+			pending = append(pending, compressPending...)
+		}
+	}
 
-				if cmdResult.Err() == nil {
-					o.log.Printf("Inserted data to redis. Size: %d. Try: %d", o.Batch, retry)
+	if cachePos > 0 {
+		o.log.Printf("Flushing %d items left in batch", cachePos)
+		client = o.push(ctx, client, keyName, cache[:cachePos], pending, counter)
+	}
 
-					counter <- o.Batch
+	client.Close()
+	o.log.Printf("Redis output to %s finished", keyName)
 
-					continue messageCycle
-				}
+	return
+}
 
-				o.log.Printf("failed to push resolve. got: %s", cmdResult.Err())
-				client = redis.NewClient(&redis.Options{
-					Addr:     o.Outputs[0].Addr,
-					Password: "",
-					DB:       0,
-				})
+func (o *RedisOutput) connect() *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:     o.Outputs[0].Addr,
+		Password: "",
+		DB:       0,
+	})
 
-				_, err = client.Ping().Result()
-				if err != nil {
-					o.log.Printf("failed to ping server => " + err.Error())
-				}
+	_, err := client.Ping().Result()
+	if err != nil {
+		o.log.Printf("failed to ping server => " + err.Error())
+	}
+
+	return client
+}
+
+/**
+ * Pack messages to gzipped JSON list
+ */
+func (o *RedisOutput) compress(items []string) ([]byte, error) {
+	encodedData, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+
+	_, err = gz.Write(encodedData)
+	if err != nil {
+		return nil, err
+	}
+	gz.Flush()
+	gz.Close()
+
+	if o.Debug {
+		compression := 100.0 * float64(buf.Len()) / float64(len(encodedData))
+		o.log.Printf("data compressed %d => %d, compression %0.2f%%", len(encodedData), buf.Len(), compression)
+	}
+
+	return buf.Bytes(), nil
+}
 
-				time.Sleep(time.Duration(2*retry) * time.Second)
+/**
+ * Push batch to redis, reconnect on failures. Messages of batch are rejected when all
+ * retries failed or output is stopped. Returns client which should be used for next batches
+ */
+func (o *RedisOutput) push(ctx context.Context, client *redis.Client, keyName string, items []interface{}, messages []structs.Message, counter chan int) *redis.Client {
+	var err error
+
+	for retry := 0; retry < redisPushRetries; retry += 1 {
+		cmdResult := client.RPush(keyName, items...)
+
+		if cmdResult.Err() == nil {
+			o.log.Printf("Inserted data to redis. Size: %d. Try: %d", len(items), retry)
+			for _, msg := range messages {
+				msg.Ack()
 			}
 
-			o.log.Print("failed to insert data batch")
+			counter <- len(items)
+
+			return client
+		}
+
+		err = cmdResult.Err()
+		o.log.Printf("failed to push resolve. got: %s", err)
+		client.Close()
+		client = o.connect()
+
+		if !o.delay(ctx, retry) {
+			o.log.Printf("Output is stopped, rejecting %d messages of batch", len(messages))
+			o.rejectAll(messages, err)
+
+			return client
 		}
 	}
 
-	return
+	o.log.Printf("failed to insert data batch after %d retries, rejecting %d messages", redisPushRetries, len(messages))
+	o.rejectAll(messages, err)
+
+	return client
+}
+
+// delay - wait before next try, false is returned when output is stopped
+func (o *RedisOutput) delay(ctx context.Context, retry int) bool {
+	select {
+	case <-time.After(time.Duration(2*retry) * time.Second):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (o *RedisOutput) rejectAll(messages []structs.Message, err error) {
+	for _, msg := range messages {
+		o.Reject(msg, err)
+	}
 }
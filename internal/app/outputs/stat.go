@@ -1,7 +1,10 @@
 package outputs
 
 import (
+	"context"
+	"errors"
 	"log"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"time"
 	"strconv"
@@ -12,37 +15,59 @@ const DEFAULT_PERIOD = 10
 type StatOutput struct {
 	BasicOutput
 
-	log log.Logger
+	log *log.Logger
 	Period int64
 }
 
-func NewStatOutput(options map[string]string, logger log.Logger) (s *StatOutput, err error) {
+// StatOptions - options of stat output
+type StatOptions struct {
+	Period string `hcl:"period,optional"`
+}
+
+func init() {
+	defaults := StatOptions{Period: strconv.Itoa(DEFAULT_PERIOD)}
+
+	registry.RegisterOutput("stat", registry.NewOutputFactory(defaults, NewStatOutput), registry.Schema{
+		Description: "Log rate of received messages",
+		Options: []registry.Option{
+			{Name: "period", Description: "reporting period, seconds or duration like 1m", Default: "10"},
+		},
+	})
+}
+
+func NewStatOutput(options StatOptions, logger *log.Logger) (s *StatOutput, err error) {
 	logger.Printf("Initializing stat output")
 
 	s = &StatOutput{}
 	s.log = logger
 
-	if _, ok := options["period"]; ok {
-		period, err := strconv.Atoi(options["period"])
-		if err != nil {
-			s.log.Printf("Failed to setup period. Incorrect value: %s, using default: %d", options["period"], DEFAULT_PERIOD)
-		}
+	period, err := registry.ParseDuration(options.Period)
+	if err != nil {
+		return nil, err
+	}
 
-		s.Period = int64(period)
-	} else {
-		s.Period = DEFAULT_PERIOD
+	if period < time.Second {
+		return nil, errors.New("incorrect period: " + options.Period + ", should be at least 1 second")
 	}
 
+	s.Period = int64(period / time.Second)
+
 	return s, nil
 }
 
-func (s *StatOutput) ReadFrom(input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
+func (s *StatOutput) ReadFrom(ctx context.Context, input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
 	s.log.Printf("Started stat output. Period: %d", s.Period)
 
 	start := time.Now().Unix()
 	i := 0
 
-	for range input {
+	for ctx.Err() == nil {
+		msg, ok := s.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+		msg.Ack()
+
 		i += 1
 
 		if start < time.Now().Unix() - s.Period {
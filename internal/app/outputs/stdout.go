@@ -1,8 +1,10 @@
 package outputs
 
 import (
+	"context"
 	"log"
 	"encoding/json"
+	"github.com/alxark/lonelog/internal/app/registry"
 	"github.com/alxark/lonelog/internal/structs"
 	"strings"
 )
@@ -10,10 +12,19 @@ import (
 type StdoutOutput struct {
 	BasicOutput
 
-	Log log.Logger
+	Log *log.Logger
 }
 
-func NewStdoutOutput(options map[string]string, logger log.Logger) (s *StdoutOutput, err error) {
+// StdoutOptions - stdout output has no options
+type StdoutOptions struct{}
+
+func init() {
+	registry.RegisterOutput("stdout", registry.NewOutputFactory(StdoutOptions{}, NewStdoutOutput), registry.Schema{
+		Description: "Print messages as JSON",
+	})
+}
+
+func NewStdoutOutput(options StdoutOptions, logger *log.Logger) (s *StdoutOutput, err error) {
 	logger.Printf("Initializing stdout output")
 
 	s = &StdoutOutput{}
@@ -21,16 +32,23 @@ func NewStdoutOutput(options map[string]string, logger log.Logger) (s *StdoutOut
 	return s, nil
 }
 
-func (s *StdoutOutput) ReadFrom(input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
-	for msg := range input {
+func (s *StdoutOutput) ReadFrom(ctx context.Context, input chan structs.Message, runtimeOptions map[string]string, counter chan int) (err error) {
+	for ctx.Err() == nil {
+		msg, ok := s.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
 		marshaled, err := json.Marshal(msg)
 		if err != nil {
-			s.Log.Printf("Failed to marshall message. Got: " + err.Error())
+			s.Log.Print("Failed to marshall message. Got: " + err.Error())
+			s.Reject(msg, err)
 			continue
 		}
 
 		s.Log.Print(string(marshaled))
 		s.Log.Print(strings.Repeat("=", 50))
+		msg.Ack()
 	}
 
 	return
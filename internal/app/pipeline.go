@@ -4,12 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/alxark/lonelog/internal/app/filters"
-	"github.com/alxark/lonelog/internal/app/inputs"
-	"github.com/alxark/lonelog/internal/app/outputs"
+	"github.com/alxark/lonelog/internal/app/expression"
+	"github.com/alxark/lonelog/internal/app/queues"
 	"github.com/alxark/lonelog/internal/structs"
 	"log"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -26,40 +27,105 @@ const (
 
 	// how often to collect service statistics about inner queues
 	defaultStatInterval = 30
+
+	// how long to wait for queues draining on shutdown
+	defaultShutdownTimeout = 30
+
+	// disk queues defaults, they could keep much more messages than memory queues
+	defaultQueueDir          = "/var/lib/lonelog"
+	defaultDiskQueueSize     = 1000000
+	defaultDiskQueueMaxBytes = 1 << 30
 )
 
 type Pipeline struct {
-	log          log.Logger
-	Inputs       []structs.Input
-	Outputs      []structs.Output
-	Filters      []structs.Filter
-	InputStream  chan structs.Message
-	OutputStream chan structs.Message
-
-	// list of filter channels
-	SubChains          []chan structs.Message
-	SubChainsNames     []string
-	ThreadsCount       []int
-	OutputThreadsCount []int
-
-	OutputSplay  int
-	StatInterval int
-	Bench        *Benchmark
+	log         *log.Logger
+	Name        string
+	InputQueue  queues.Queue
+	OutputQueue queues.Queue
+
+	// queues between filters, queue #i is written by filter #i
+	SubChains      []queues.Queue
+	SubChainsNames []string
+
+	// directory and segment size of disk queues
+	queueDir          string
+	queueSegmentBytes int64
+
+	OutputSplay     int
+	StatInterval    int
+	ShutdownTimeout int
+	Bench           *Benchmark
 
 	Status PipelineStatus
+
+	inputs  []*stage
+	filters []*stage
+	outputs []*stage
+
+	// queues read by outputs, see delivery
+	deliveries []*delivery
+
+	// messages rejected by filters and outputs, they are passed to dead letter
+	// output when it's configured and logged otherwise
+	DeadLetterStream chan structs.DeadLetter
+	deadLetterQueue  chan structs.Message
+	deadLetter       []*stage
+
+	// writers of every queue, queue is closed when all of them are finished
+	inputsGroup  *sync.WaitGroup
+	filterGroups []*sync.WaitGroup
+	outputsGroup *sync.WaitGroup
+
+	// dead letter output threads, it's stopped after all other stages
+	deadLetterGroup *sync.WaitGroup
+
+	// protects stages from concurrent update and shutdown
+	mutex    sync.Mutex
+	stopping bool
+
+	// ctx is cancelled only when shutdown timeout is reached, inputCtx is
+	// cancelled first to stop accepting new messages
+	ctx        context.Context
+	abort      context.CancelFunc
+	inputCtx   context.Context
+	stopInputs context.CancelFunc
+
+	// closed when all outputs including dead letter output are finished
+	done chan struct{}
+
+	// stages of running pipeline which are kept on reload, they are not created
+	reuse *stageReuse
 }
 
-func NewPipeline(configuration Configuration, logger log.Logger) (p Pipeline, err error) {
-	p.log = logger
+func NewPipeline(configuration PipelineConfiguration, global GlobalConfiguration, logger *log.Logger) (p *Pipeline, err error) {
+	return newPipeline(configuration, global, logger, nil)
+}
+
+/**
+ * Create pipeline, stages taken from reuse are not created and initialized, because
+ * running ones are kept by Update. Such pipeline can't be started, see stageReuse
+ */
+func newPipeline(configuration PipelineConfiguration, global GlobalConfiguration, logger *log.Logger, reuse *stageReuse) (p *Pipeline, err error) {
+	p = &Pipeline{reuse: reuse}
+	p.Name = configuration.Name
+	// all messages of pipeline and its plugins are prefixed with pipeline name
+	p.log = log.New(logger.Writer(), logger.Prefix()+"["+p.Name+"] ", logger.Flags()|log.Lmsgprefix)
 	p.log.Println("Initializing new pipeline")
 	p.Bench, _ = NewBenchmark(p.log)
+	p.inputsGroup = &sync.WaitGroup{}
+	p.outputsGroup = &sync.WaitGroup{}
+	p.deadLetterGroup = &sync.WaitGroup{}
 
-	inputStreamSize := defaultChannelSize
-	if configuration.In.Queue > 0 {
-		inputStreamSize = configuration.In.Queue
+	p.queueDir = defaultQueueDir
+	if global.QueueDir != "" {
+		p.queueDir = global.QueueDir
+	}
+	p.queueSegmentBytes = global.QueueSegmentBytes
+
+	p.InputQueue, err = p.newQueue("input", configuration.In.queueOptions())
+	if err != nil {
+		return
 	}
-	p.log.Printf("Initialized input queue size: %d", inputStreamSize)
-	p.InputStream = make(chan structs.Message, inputStreamSize)
 
 	err = p.setupInputs(configuration.In.Input)
 	if err != nil {
@@ -71,30 +137,48 @@ func NewPipeline(configuration Configuration, logger log.Logger) (p Pipeline, er
 		return
 	}
 
-	outputStreamSize := defaultChannelSize
-	if configuration.Out.Queue > 0 {
-		outputStreamSize = configuration.Out.Queue
+	if len(p.filters) == 0 {
+		p.log.Printf("No filters configured! Linking output and input plugins directly")
+		p.OutputQueue = p.InputQueue
+	} else {
+		p.OutputQueue, err = p.newQueue("output", configuration.Out.queueOptions())
+		if err != nil {
+			return
+		}
 	}
-	p.log.Printf("Initialized output queue size: %d", outputStreamSize)
-	p.OutputStream = make(chan structs.Message, outputStreamSize)
 
 	err = p.setupOutput(configuration.Out.Output)
 	if err != nil {
 		return
 	}
 
-	if configuration.Global.StatInterval > 0 {
-		p.StatInterval = configuration.Global.StatInterval
+	err = p.setupDeadLetter(configuration.DeadLetter)
+	if err != nil {
+		return
+	}
+
+	if global.StatInterval > 0 {
+		p.StatInterval = global.StatInterval
 	} else {
 		p.StatInterval = defaultStatInterval
 	}
 
-	if configuration.Global.OutputSplay > 0 {
-		p.OutputSplay = configuration.Global.OutputSplay
+	if global.OutputSplay > 0 {
+		p.OutputSplay = global.OutputSplay
 	} else {
 		p.OutputSplay = defaultOutputSplay
 	}
 
+	if global.ShutdownTimeout > 0 {
+		p.ShutdownTimeout = global.ShutdownTimeout
+	} else {
+		p.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	p.ctx, p.abort = context.WithCancel(context.Background())
+	p.inputCtx, p.stopInputs = context.WithCancel(p.ctx)
+	p.done = make(chan struct{})
+
 	return
 }
 
@@ -104,38 +188,32 @@ func (p *Pipeline) setupInputs(inputsList []InputPlugin) (err error) {
 	for _, v := range inputsList {
 		p.log.Printf("processing input %s", v.Name)
 
-		var inputPlugin structs.Input
-		switch v.Plugin {
-		case "syslog":
-			inputPlugin, err = inputs.NewSyslog(v.Options.Data, p.log)
-			break
-		case "redis":
-			inputPlugin, err = inputs.NewRedisInput(v.Options.Data, p.log)
-			break
+		signature := stageSignature(v, v.Options.Values())
+		if p.reuse.takeInput(signature) {
+			p.inputs = append(p.inputs, &stage{name: v.Name, signature: signature})
+			continue
 		}
 
+		inputPlugin, err := p.newInput(v)
 		if err != nil {
 			return err
 		}
 
-		inputPlugin.SetName(v.Name)
-
-		if inputPlugin.Init() != nil {
-			return errors.New("failed to initialize input plugin: " + err.Error())
-		}
-
+		threads := 1
 		if v.Threads > 1 {
 			if inputPlugin.IsMultiThread() {
-				for i := 0; i < v.Threads; i += 1 {
-					p.Inputs = append(p.Inputs, inputPlugin)
-				}
+				threads = v.Threads
 			} else {
 				p.log.Printf("multi-threaded mode is not support for %s", v.Plugin)
-				p.Inputs = append(p.Inputs, inputPlugin)
 			}
-		} else {
-			p.Inputs = append(p.Inputs, inputPlugin)
 		}
+
+		p.inputs = append(p.inputs, &stage{
+			name:      v.Name,
+			threads:   threads,
+			signature: signature,
+			input:     inputPlugin,
+		})
 	}
 
 	return
@@ -145,105 +223,169 @@ func (p *Pipeline) setupFilters(filtersList []FilterPlugin) (err error) {
 	p.log.Printf("Total filters available: %d", len(filtersList))
 
 	for i, v := range filtersList {
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("Filter #%d", i)
+		}
+
+		if v.ServiceInterval == 0 {
+			v.ServiceInterval = defaultServiceInterval
+		}
+
+		if v.Threads == 0 {
+			v.Threads = 1
+		}
+
+		signature := stageSignature(v, v.options().Values())
+
 		var filterPlugin structs.Filter
+		if !p.reuse.takeFilter(i, signature) {
+			if filterPlugin, err = p.newFilter(v); err != nil {
+				return err
+			}
+		}
 
-		switch v.Plugin {
-		case "split":
-			filterPlugin, err = filters.NewSplitFilter(v.Options.Data, p.log)
-			break
-		case "substring":
-			filterPlugin, err = filters.NewSubstringFilter(v.Options.Data, p.log)
-			break
-		case "rename":
-			filterPlugin, err = filters.NewRenameFilter(v.Options.Data, p.log)
-			break
-		case "regexp":
-			filterPlugin, err = filters.NewRegexpFilter(v.Options.Data, p.log)
-			break
-		case "regexp_remove":
-			filterPlugin, err = filters.NewRegexpRemoveFilter(v.Options.Data, p.log)
-			break
-		case "regexp_classify":
-			filterPlugin, err = filters.NewRegexpClassifyFilter(v.Options.Data, p.log)
-			break
-		case "regexp_match":
-			filterPlugin, err = filters.NewRegexpMatchFilter(v.Options.Data, p.log)
-			break
-		case "payload_assert":
-			filterPlugin, err = filters.NewPayloadAssertFilter(v.Options.Data, p.log)
-			break
-		case "payload_equal":
-			filterPlugin, err = filters.NewPayloadEqualFilter(v.Options.Data, p.log)
-			break
-		case "payload_dump":
-			filterPlugin, err = filters.NewPayloadDumpFilter(v.Options.Data, p.log)
-			break
-		case "geoip":
-			filterPlugin, err = filters.NewGeoipFilter(v.Options.Data, p.log)
-			break
-		case "set":
-			filterPlugin, err = filters.NewSetFilter(v.Options.Data, p.log)
-			break
-		case "time_format":
-			filterPlugin, err = filters.NewTimeFormatFilter(v.Options.Data, p.log)
-			break
-		case "substr_contains":
-			filterPlugin, err = filters.NewSubstrContainsFilter(v.Options.Data, p.log)
-			break
-		case "copy":
-			filterPlugin, err = filters.NewCopyFilter(v.Options.Data, p.log)
-			break
-		case "tcp_tee":
-			filterPlugin, err = filters.NewTcpTeeFilter(v.Options.Data, p.log)
-			break
-		case "web_rpc":
-			filterPlugin, err = filters.NewWebRpcFilter(v.Options.Data, p.log)
-			break
-		default:
-			return errors.New(fmt.Sprintf("plugin #%d not found: %s", i, v.Plugin))
+		p.filters = append(p.filters, &stage{
+			name:      v.Name,
+			threads:   v.Threads,
+			signature: signature,
+			filter:    filterPlugin,
+		})
+		p.filterGroups = append(p.filterGroups, &sync.WaitGroup{})
+
+		// last filter writes to output queue
+		if i == len(filtersList)-1 {
+			continue
 		}
 
+		p.log.Printf("Creating sub-chain for #%d", i)
+		chain, err := p.newQueue("filter-"+v.Name, v.queueOptions())
 		if err != nil {
 			return err
 		}
+		p.SubChains = append(p.SubChains, chain)
+		p.SubChainsNames = append(p.SubChainsNames, v.Name)
+	}
 
-		if v.Name == "" {
-			v.Name = fmt.Sprintf("Filter #%d", i)
+	p.log.Printf("Configured %d top level filters", len(p.filters))
+
+	return nil
+}
+
+// newInput - create and initialize input plugin
+func (p *Pipeline) newInput(v InputPlugin) (inputPlugin structs.Input, err error) {
+	inputPlugin, err = v.create(p.log)
+	if err != nil {
+		return nil, err
+	}
+
+	inputPlugin.SetName(v.Name)
+	inputPlugin.SetPipeline(p.Name)
+
+	if err := inputPlugin.Init(); err != nil {
+		return nil, errors.New("failed to initialize input plugin: " + err.Error())
+	}
+
+	return inputPlugin, nil
+}
+
+// newFilter - create and initialize filter plugin
+func (p *Pipeline) newFilter(v FilterPlugin) (filterPlugin structs.Filter, err error) {
+	filterPlugin, err = v.create(p.log)
+	if err != nil {
+		return nil, err
+	}
+
+	filterPlugin.SetName(v.Name)
+	filterPlugin.SetPipeline(p.Name)
+	filterPlugin.SetField(v.Field)
+	filterPlugin.SetLogger(p.log)
+
+	if v.Debug {
+		filterPlugin.SetDebug(true)
+		p.log.Printf("Activated debug mode for %s", v.Name)
+	}
+
+	filterPlugin.SetServiceInterval(v.ServiceInterval)
+
+	var condition *expression.Expression
+	if v.When != "" {
+		if condition, err = expression.Compile(v.When); err != nil {
+			return nil, errors.New("invalid when expression: " + err.Error())
 		}
-		filterPlugin.SetName(v.Name)
-		filterPlugin.SetField(v.Field)
+	}
 
-		if v.ServiceInterval == 0 {
-			v.ServiceInterval = defaultServiceInterval
+	if err := filterPlugin.Init(); err != nil {
+		return nil, errors.New("failed to initialize filter: " + err.Error())
+	}
+
+	// messages which are not selected by expression and tags bypass filter
+	filterPlugin = newConditionalFilter(filterPlugin, condition, newTagSelector(v.OnlyTags, v.SkipTags))
+
+	return filterPlugin, nil
+}
+
+/**
+ * Create queue between stages. Disk queues are stored in directory named after
+ * pipeline and queue, they are opened only when pipeline is started
+ */
+func (p *Pipeline) newQueue(name string, options queues.Options) (queues.Queue, error) {
+	options.Pipeline = p.Name
+	options.Name = name
+
+	if options.Type == queues.TypeDisk {
+		if options.Size <= 0 {
+			options.Size = defaultDiskQueueSize
 		}
 
-		if v.Debug {
-			filterPlugin.SetDebug(true)
-			p.log.Printf("Activated debug mode for %s", v.Name)
+		if options.MaxBytes <= 0 {
+			options.MaxBytes = defaultDiskQueueMaxBytes
 		}
 
-		filterPlugin.SetServiceInterval(v.ServiceInterval)
-		p.Filters = append(p.Filters, filterPlugin)
+		options.Dir = filepath.Join(p.queueDir, p.Name, name)
+		options.SegmentBytes = p.queueSegmentBytes
+	} else if options.Size <= 0 {
+		options.Size = defaultChannelSize
+	}
+
+	q, err := queues.New(options, p.log)
+	if err != nil {
+		return nil, errors.New("failed to create " + name + " queue: " + err.Error())
+	}
 
-		queueSize := defaultChannelSize
-		if v.Queue > 0 {
-			queueSize = v.Queue
+	options = q.Options()
+	p.log.Printf("Initialized %s queue %s, size: %d, overflow: %s", name, options.Type, options.Size, options.Overflow)
+
+	return q, nil
+}
+
+// openQueues - open all stage queues, persistent queues replay unacknowledged messages
+func (p *Pipeline) openQueues() error {
+	for _, q := range p.queues() {
+		if err := q.Open(); err != nil {
+			return err
 		}
+	}
 
-		p.log.Printf("Creating sub-chain for #%d, size: %d", i, queueSize)
-		chain := make(chan structs.Message, queueSize)
-		p.SubChains = append(p.SubChains, chain)
-		p.SubChainsNames = append(p.SubChainsNames, v.Name)
+	return nil
+}
 
-		if v.Threads == 0 {
-			v.Threads = 1
+// stopQueues - release queues when all stages are finished
+func (p *Pipeline) stopQueues() {
+	for _, q := range p.queues() {
+		if err := q.Stop(); err != nil {
+			p.log.Printf("Failed to stop queue: %s", err.Error())
 		}
-		p.ThreadsCount = append(p.ThreadsCount, v.Threads)
 	}
+}
 
-	p.log.Printf("Configured %d top level filters", len(p.Filters))
+// queues - list of stage queues, output queue is not included when it's the same as input queue
+func (p *Pipeline) queues() []queues.Queue {
+	list := append([]queues.Queue{p.InputQueue}, p.SubChains...)
+	if p.OutputQueue != p.InputQueue {
+		list = append(list, p.OutputQueue)
+	}
 
-	return nil
+	return list
 }
 
 /**
@@ -251,143 +393,514 @@ func (p *Pipeline) setupFilters(filtersList []FilterPlugin) (err error) {
  */
 func (p *Pipeline) setupOutput(outputsList []OutputPlugin) (err error) {
 	for _, v := range outputsList {
-		var outputPlugin structs.Output
+		signature := stageSignature(v, v.Options.Values())
 
-		switch v.Plugin {
-		case "stdout":
-			outputPlugin, err = outputs.NewStdoutOutput(v.Options.Data, p.log)
-			break
-		case "stat":
-			outputPlugin, err = outputs.NewStatOutput(v.Options.Data, p.log)
-			break
-		case "clickhouse":
-			outputPlugin, err = outputs.NewClickhouseOutput(v.Options.Data, p.log)
-			break
-		case "redis":
-			outputPlugin, err = outputs.NewRedisOutput(v.Options.Data, p.log)
-			break
-		case "null":
-			outputPlugin, err = outputs.NewNullOutput(v.Options.Data, p.log)
-			break
-		default:
-			return errors.New("failed to initialize output: " + v.Plugin)
+		var outputPlugin structs.Output
+		if !p.reuse.takeOutput(signature) {
+			if outputPlugin, err = p.newOutput(v); err != nil {
+				return err
+			}
 		}
 
-		if err != nil {
-			return err
-		}
 		threadsCount := 1
 		if v.Threads > 0 {
 			threadsCount = v.Threads
 		}
 
-		outputPlugin.SetName(v.Name)
-
-		if v.Debug {
-			outputPlugin.SetDebug(true)
-			p.log.Printf("Activated debug mode for output plugin")
+		deliveryName, err := p.addDelivery(v)
+		if err != nil {
+			return err
 		}
 
-		p.Outputs = append(p.Outputs, outputPlugin)
-		p.OutputThreadsCount = append(p.OutputThreadsCount, threadsCount)
+		p.outputs = append(p.outputs, &stage{
+			name:      v.Name,
+			threads:   threadsCount,
+			signature: signature,
+			output:    outputPlugin,
+			delivery:  deliveryName,
+		})
 	}
 
+	p.setupDeliveries()
+
 	p.log.Printf("Output initialization finished")
 
 	return nil
 }
 
 /**
- * Proceed pipeline stuff
+ * Setup dead letter output. It could be any output plugin, it gets rejected
+ * messages with failure details, see structs.DeadLetter
  */
-func (p *Pipeline) Run() (err error) {
-	ctx := context.Background()
+func (p *Pipeline) setupDeadLetter(v *OutputPlugin) (err error) {
+	size := defaultChannelSize
+	if v != nil && v.Queue > 0 {
+		size = v.Queue
+	}
+	p.DeadLetterStream = make(chan structs.DeadLetter, size)
+
+	if v == nil {
+		return nil
+	}
+
+	if v.Mode != "" || v.Route != "" || len(v.OnlyTags) > 0 || len(v.SkipTags) > 0 {
+		return errors.New("mode, route and tags are not supported by dead letter output " + v.Name)
+	}
+
+	signature := stageSignature(v, v.Options.Values())
+
+	var outputPlugin structs.Output
+	if !p.reuse.takeDeadLetter(signature) {
+		if outputPlugin, err = p.newOutput(*v); err != nil {
+			return err
+		}
+	}
+
+	threadsCount := 1
+	if v.Threads > 0 {
+		threadsCount = v.Threads
+	}
+
+	p.log.Printf("Dead letter output %s (%s) configured", v.Name, v.Plugin)
+	p.deadLetterQueue = make(chan structs.Message, size)
+	p.deadLetter = append(p.deadLetter, &stage{
+		name:      v.Name,
+		threads:   threadsCount,
+		signature: signature,
+		output:    outputPlugin,
+	})
+
+	return nil
+}
+
+// newOutput - create and initialize output plugin
+func (p *Pipeline) newOutput(v OutputPlugin) (outputPlugin structs.Output, err error) {
+	outputPlugin, err = v.create(p.log)
+	if err != nil {
+		return nil, err
+	}
+
+	outputPlugin.SetName(v.Name)
+	outputPlugin.SetPipeline(p.Name)
+	outputPlugin.SetLogger(p.log)
+
+	if v.Debug {
+		outputPlugin.SetDebug(true)
+		p.log.Printf("Activated debug mode for output plugin")
+	}
+
+	if err := outputPlugin.Init(); err != nil {
+		return nil, err
+	}
+
+	return outputPlugin, nil
+}
 
+/**
+ * Proceed pipeline stuff. Error is returned when pipeline could not be started,
+ * such pipeline is finished and can't be updated
+ */
+func (p *Pipeline) Run() (err error) {
 	p.log.Printf("Starting pipeline processing")
 
-	for i, input := range p.Inputs {
-		p.log.Printf("Activating input ID#%d", i)
-		go input.AcceptTo(p.InputStream, p.Bench.NewChannel("input"))
+	if err := p.openQueues(); err != nil {
+		p.mutex.Lock()
+		p.stopping = true
+		p.mutex.Unlock()
+
+		p.stopQueues()
+		close(p.done)
+
+		return errors.New("failed to open pipeline queues: " + err.Error())
 	}
 
-	if len(p.Filters) == 0 {
-		p.log.Printf("No filters configured! Linking output and input plugins directly")
+	p.mutex.Lock()
+	for i, s := range p.inputs {
+		p.log.Printf("Activating input ID#%d, threads: %d", i, s.threads)
+		p.startInput(s)
+	}
 
-		p.OutputStream = p.InputStream
-	} else {
-		// we have some filters available, let's link them one by one
+	// input queue is closed only when all inputs are stopped
+	go p.closeAfter(p.inputsGroup, p.InputQueue)
 
-		for i, filter := range p.Filters {
-			p.log.Printf("Activating filter #%d", i)
+	for i, s := range p.filters {
+		p.log.Printf("Activating filter #%d, threads: %d", i, s.threads)
+		p.startFilter(i, s)
 
-			if err := filter.Init(); err != nil {
-				p.log.Fatal("failed to initialize filter: " + err.Error())
-				return err
+		// next queue is closed when all threads of this filter drained their input
+		_, output := p.filterStreams(i)
+		go p.closeAfter(p.filterGroups[i], output)
+	}
+
+	if p.isDispatched() {
+		go p.dispatch()
+	}
+
+	go p.processDeadLetters()
+	for _, s := range p.deadLetter {
+		p.log.Printf("Activating dead letter output %s, threads: %d", s.name, s.threads)
+		p.startDeadLetter(s)
+	}
+
+	for i, s := range p.outputs {
+		p.log.Printf("Activating output ID#%d, threads: %d, splay: %d", i, s.threads, p.OutputSplay)
+		p.startOutput(s)
+	}
+	p.mutex.Unlock()
+
+	// dead letter queue is closed when all filters and outputs are finished
+	go func() {
+		for _, group := range p.filterGroups {
+			group.Wait()
+		}
+		p.outputsGroup.Wait()
+		close(p.DeadLetterStream)
+	}()
+
+	go func() {
+		p.outputsGroup.Wait()
+		p.deadLetterGroup.Wait()
+		p.stopQueues()
+		close(p.done)
+	}()
+
+	go p.Bench.Process()
+
+	p.log.Printf("Starting stat check, duration: %d", p.StatInterval)
+
+	ticker := time.NewTicker(time.Duration(p.StatInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			p.log.Printf("Pipeline processing finished")
+			return nil
+		case <-ticker.C:
+			p.Status = p.GetStatus()
+			p.Status.Export()
+		}
+	}
+}
+
+func (p *Pipeline) startInput(s *stage) {
+	counter := p.Bench.NewChannel("input")
+
+	s.start(p.inputCtx, p.inputsGroup, 0, func(ctx context.Context, thread int) {
+		if err := s.input.AcceptTo(ctx, p.InputQueue.In(), counter); err != nil {
+			p.log.Printf("Input %s finished with error: %s", s.name, err.Error())
+		}
+	})
+}
+
+func (p *Pipeline) startFilter(i int, s *stage) {
+	input, output := p.filterStreams(i)
+
+	s.filter.SetDeadLetter(p.DeadLetterStream)
+	s.start(p.ctx, p.filterGroups[i], 0, func(ctx context.Context, thread int) {
+		if err := s.filter.Proceed(ctx, input.Out(), output.In()); err != nil {
+			p.log.Printf("Filter %s finished with error: %s", s.name, err.Error())
+		}
+	})
+}
+
+func (p *Pipeline) startOutput(s *stage) {
+	counter := p.Bench.NewChannel("output")
+	splay := time.Duration(p.OutputSplay) * time.Second
+	stream := p.getDelivery(s.delivery).stream
+
+	s.output.SetDeadLetter(p.DeadLetterStream)
+	s.start(p.ctx, p.outputsGroup, splay, func(ctx context.Context, thread int) {
+		options := make(map[string]string)
+		options["THREAD"] = strconv.Itoa(thread)
+
+		if err := s.output.ReadFrom(ctx, stream, options, counter); err != nil {
+			p.log.Printf("Output %s finished with error: %s", s.name, err.Error())
+		}
+	})
+}
+
+/**
+ * Start dead letter output. It has no dead letter queue, messages which it could
+ * not deliver are logged with pipeline logger and acknowledged, see BasicOutput.Reject
+ */
+func (p *Pipeline) startDeadLetter(s *stage) {
+	counter := p.Bench.NewChannel("dead_letter")
+
+	s.output.SetDeadLetter(nil)
+
+	s.start(p.ctx, p.deadLetterGroup, 0, func(ctx context.Context, thread int) {
+		options := make(map[string]string)
+		options["THREAD"] = strconv.Itoa(thread)
+
+		if err := s.output.ReadFrom(ctx, p.deadLetterQueue, options, counter); err != nil {
+			p.log.Printf("Dead letter output %s finished with error: %s", s.name, err.Error())
+		}
+	})
+}
+
+/**
+ * Convert rejected messages to dead letter output messages until dead letter
+ * queue is closed. Without dead letter output they are logged, so no message
+ * is lost without a trace
+ */
+func (p *Pipeline) processDeadLetters() {
+	for deadLetter := range p.DeadLetterStream {
+		if p.deadLetterQueue == nil {
+			p.log.Printf("Dead letter from %s at %s: %s, payload: %v", deadLetter.Stage,
+				deadLetter.Time.Format(time.RFC3339), deadLetter.Error, deadLetter.Message.Payload)
+			deadLetter.Message.Ack()
+			continue
+		}
+
+		p.deadLetterQueue <- deadLetter.ToMessage()
+	}
+
+	if p.deadLetterQueue != nil {
+		close(p.deadLetterQueue)
+	}
+}
+
+/**
+ * Stop inputs and wait until all queued messages are processed by filters and
+ * flushed by outputs. Processing is aborted when shutdown timeout is reached
+ */
+func (p *Pipeline) Stop() error {
+	p.mutex.Lock()
+	p.stopping = true
+	p.mutex.Unlock()
+
+	p.log.Printf("Stopping pipeline, shutdown timeout: %d seconds", p.ShutdownTimeout)
+	p.stopInputs()
+
+	select {
+	case <-p.done:
+		p.log.Printf("Pipeline stopped, all queues drained")
+		return nil
+	case <-time.After(p.shutdownTimeout()):
+		p.abort()
+		p.stopQueues()
+		return errors.New(fmt.Sprintf("shutdown timeout reached, %d messages left in queues", p.queued()))
+	}
+}
+
+// shutdownTimeout - time given to stages to process current messages
+func (p *Pipeline) shutdownTimeout() time.Duration {
+	return time.Duration(p.ShutdownTimeout) * time.Second
+}
+
+/**
+ * Release pipeline which was built but never started. Plugins acquire their
+ * resources when they are started, so only queues are released
+ */
+func (p *Pipeline) discard() {
+	p.mutex.Lock()
+	p.stopping = true
+	p.mutex.Unlock()
+
+	p.stopQueues()
+}
+
+// isStopping - stopping pipeline can't be updated in place
+func (p *Pipeline) isStopping() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.stopping
+}
+
+// IsCompatible - check if pipeline could be updated in place, queues layout should be the same
+func (p *Pipeline) IsCompatible(next *Pipeline) bool {
+	if len(p.filters) != len(next.filters) {
+		return false
+	}
+
+	if p.InputQueue.Options() != next.InputQueue.Options() || p.OutputQueue.Options() != next.OutputQueue.Options() {
+		return false
+	}
+
+	for i := range p.SubChains {
+		if p.SubChains[i].Options() != next.SubChains[i].Options() {
+			return false
+		}
+	}
+
+	if len(p.deliveries) != len(next.deliveries) {
+		return false
+	}
+
+	for i, d := range p.deliveries {
+		n := next.deliveries[i]
+		if d.name != n.name || d.broadcast != n.broadcast || d.overflow.Policy != n.overflow.Policy || d.overflow.SampleRate != n.overflow.SampleRate || d.size != n.size || d.route != n.route {
+			return false
+		}
+	}
+
+	if cap(p.DeadLetterStream) != cap(next.DeadLetterStream) || (p.deadLetterQueue == nil) != (next.deadLetterQueue == nil) {
+		return false
+	}
+
+	return true
+}
+
+/**
+ * Replace changed stages with stages of next pipeline. Queues are kept, so
+ * messages which are already accepted are processed by new stages
+ */
+func (p *Pipeline) Update(next *Pipeline) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stopping {
+		return errors.New("pipeline is stopping")
+	}
+
+	if !p.IsCompatible(next) {
+		return errors.New("queues layout changed")
+	}
+
+	p.OutputSplay = next.OutputSplay
+	p.ShutdownTimeout = next.ShutdownTimeout
+
+	// queue groups are held during update, so no queue is closed while
+	// old stage is already stopped and new one is not started yet
+	p.inputsGroup.Add(1)
+	defer p.inputsGroup.Done()
+	p.outputsGroup.Add(1)
+	defer p.outputsGroup.Done()
+	p.deadLetterGroup.Add(1)
+	defer p.deadLetterGroup.Done()
+
+	p.inputs = p.updateStages("input", p.inputs, next.inputs, p.startInput)
+
+	for i, s := range p.filters {
+		if s.signature == next.filters[i].signature {
+			continue
+		}
+
+		p.log.Printf("Replacing filter %s with %s", s.name, next.filters[i].name)
+
+		p.filterGroups[i].Add(1)
+		if err := s.stop(p.shutdownTimeout()); err != nil {
+			p.log.Printf("Filter %s is not stopped: %s", s.name, err.Error())
+		}
+		p.filters[i] = next.filters[i]
+		if i < len(p.SubChainsNames) {
+			p.SubChainsNames[i] = next.SubChainsNames[i]
+		}
+		p.startFilter(i, p.filters[i])
+		p.filterGroups[i].Done()
+	}
+
+	p.outputs = p.updateStages("output", p.outputs, next.outputs, p.startOutput)
+	p.deadLetter = p.updateStages("dead letter output", p.deadLetter, next.deadLetter, p.startDeadLetter)
+
+	return nil
+}
+
+/**
+ * Keep stages which are not changed, stop removed ones and start new. Old stages
+ * are stopped first, because new ones could use the same resources (ports)
+ */
+func (p *Pipeline) updateStages(kind string, current []*stage, next []*stage, start func(*stage)) (result []*stage) {
+	kept := make(map[*stage]bool)
+	var added []*stage
+
+nextStages:
+	for _, n := range next {
+		for _, s := range current {
+			if !kept[s] && s.signature == n.signature {
+				kept[s] = true
+				result = append(result, s)
+				continue nextStages
 			}
+		}
+
+		added = append(added, n)
+	}
 
-			for thread := 0; thread < p.ThreadsCount[i]; thread += 1 {
-				p.log.Printf("Activating thread %d", thread)
-
-				if i == 0 && len(p.Filters) == 1 {
-					p.log.Print("Single filter mode activated")
-					go filter.Proceed(ctx, p.InputStream, p.OutputStream)
-				} else if i == 0 && len(p.Filters) > 1 {
-					p.log.Printf("First filter to chain pipeline activated")
-					go filter.Proceed(ctx, p.InputStream, p.SubChains[i])
-				} else if i > 0 && i == len(p.Filters)-1 {
-					p.log.Printf("Last filter in chain, #%d", i)
-					go filter.Proceed(ctx, p.SubChains[i-1], p.OutputStream)
-				} else if i > 0 && i != len(p.Filters)-1 {
-					p.log.Printf("Middle filter in chain, #%d", i)
-					go filter.Proceed(ctx, p.SubChains[i-1], p.SubChains[i])
-				}
+	for _, s := range current {
+		if !kept[s] {
+			p.log.Printf("Stopping %s %s", kind, s.name)
+			if err := s.stop(p.shutdownTimeout()); err != nil {
+				p.log.Printf("The %s %s is not stopped: %s", kind, s.name, err.Error())
 			}
 		}
 	}
 
-	for i, output := range p.Outputs {
-		for j := 0; j < p.OutputThreadsCount[i]; j += 1 {
-			options := make(map[string]string)
+	for _, s := range added {
+		p.log.Printf("Starting %s %s", kind, s.name)
+		start(s)
+		result = append(result, s)
+	}
 
-			p.log.Printf("Activating output ID#%d, thread %d", i, j)
+	return
+}
 
-			options["THREAD"] = strconv.Itoa(j)
+// filterStreams - get input and output queues for filter #i
+func (p *Pipeline) filterStreams(i int) (input queues.Queue, output queues.Queue) {
+	input = p.InputQueue
+	if i > 0 {
+		input = p.SubChains[i-1]
+	}
 
-			go output.ReadFrom(p.OutputStream, options, p.Bench.NewChannel("output"))
-			p.log.Printf("Waiting for %d seconds before next activation", p.OutputSplay)
-			time.Sleep(time.Duration(p.OutputSplay) * time.Second)
-		}
+	output = p.OutputQueue
+	if i < len(p.filters)-1 {
+		output = p.SubChains[i]
 	}
-	go p.Bench.Process()
 
-	p.log.Printf("Starting stat check, duration: %d", p.StatInterval)
+	return
+}
 
-	for {
-		time.Sleep(time.Duration(p.StatInterval) * time.Second)
-		p.Status = p.GetStatus()
+// closeAfter - close queue when all its writers are finished
+func (p *Pipeline) closeAfter(writers *sync.WaitGroup, queue queues.Queue) {
+	writers.Wait()
+	queue.Close()
+}
+
+// queued - total number of messages in pipeline queues
+func (p *Pipeline) queued() int {
+	total := 0
+	for _, q := range p.queues() {
+		total += q.Len()
 	}
+	total += len(p.DeadLetterStream) + len(p.deadLetterQueue)
+
+	return total
 }
 
 func (p *Pipeline) GetStatus() PipelineStatus {
-	currentStatus := PipelineStatus{}
+	currentStatus := PipelineStatus{Name: p.Name}
 	currentStatus.In = PluginStatus{
 		Name:      "input",
-		Size:      len(p.InputStream),
+		Size:      p.InputQueue.Len(),
 		Benchmark: p.Bench.GetPluginBenchmark("input"),
 	}
 
 	currentStatus.Out = PluginStatus{
 		Name:      "output",
-		Size:      len(p.OutputStream),
+		Size:      p.OutputQueue.Len(),
 		Benchmark: p.Bench.GetPluginBenchmark("output"),
 	}
 
 	var filterStatuses []PluginStatus
 
-	for i, channel := range p.SubChains {
-		filterStatuses = append(filterStatuses, PluginStatus{Name: "filter-" + p.SubChainsNames[i], Size: len(channel)})
+	for i, chain := range p.SubChains {
+		filterStatuses = append(filterStatuses, PluginStatus{Name: "filter-" + p.SubChainsNames[i], Size: chain.Len()})
 	}
 
 	currentStatus.Filters = filterStatuses
+
+	currentStatus.DeadLetter = PluginStatus{
+		Name:      "dead_letter",
+		Size:      len(p.DeadLetterStream) + len(p.deadLetterQueue),
+		Benchmark: p.Bench.GetPluginBenchmark("dead_letter"),
+	}
+
+	if p.isDispatched() {
+		for _, d := range p.deliveries {
+			currentStatus.Outputs = append(currentStatus.Outputs, PluginStatus{Name: "output-" + d.name, Size: len(d.stream)})
+		}
+	}
 	return currentStatus
 }
@@ -1,26 +1,45 @@
 package app
 
+import (
+	"github.com/alxark/lonelog/internal/app/queues"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/hashicorp/hcl/v2"
+	"log"
+)
+
 type InputPlugin struct {
-	Name    string `hcl:",label"`
-	Plugin  string `hcl:"plugin"`
-	Threads int    `hcl:"threads,optional"`
-	Options struct {
-		Data map[string]string `hcl:",remain"`
-	} `hcl:"options,block"`
+	Name    string           `hcl:",label"`
+	Plugin  string           `hcl:"plugin"`
+	Threads int              `hcl:"threads,optional"`
+	Options registry.Options `hcl:"options,block"`
+	// creates plugin instead of registered one, used by applications embedding pipeline
+	Factory registry.InputFactory `json:"-"`
+	// block body, used to report positions of configuration errors
+	Body hcl.Body `hcl:",body" json:"-"`
 }
 
 type FilterPlugin struct {
-	Name            string `hcl:",label"`
-	Threads         int    `hcl:"threads,optional"`
-	ServiceInterval int    `hcl:"service_interval,optional"`
-	Plugin          string `hcl:"plugin"`
-	Field           string `hcl:"field,optional"`
-	Queue           int    `hcl:"queue,optional"`
-	Debug           bool   `hcl:"debug,optional"`
-	Options         struct {
-		Data map[string]string `hcl:",remain"`
-	} `hcl:"options,block"`
-	Args []map[string]string `hcl:"arg,optional"`
+	Name            string   `hcl:",label"`
+	Threads         int      `hcl:"threads,optional"`
+	ServiceInterval int      `hcl:"service_interval,optional"`
+	Plugin          string   `hcl:"plugin,optional"`
+	Chain           string   `hcl:"chain,optional"`
+	Field           string   `hcl:"field,optional"`
+	Queue           int      `hcl:"queue,optional"`
+	QueueType       string   `hcl:"queue_type,optional"`
+	QueueMaxBytes   int64    `hcl:"queue_max_bytes,optional"`
+	Overflow        string   `hcl:"overflow,optional"`
+	OverflowSample  int      `hcl:"overflow_sample,optional"`
+	Debug           bool     `hcl:"debug,optional"`
+	When            string   `hcl:"when,optional"`
+	OnlyTags        []string `hcl:"only_tags,optional"`
+	SkipTags        []string `hcl:"skip_tags,optional"`
+	// options block is optional, chain references and some plugins have no options
+	Options *registry.Options      `hcl:"options,block"`
+	Args    []map[string]string    `hcl:"arg,optional"`
+	Factory registry.FilterFactory `json:"-"`
+	Body    hcl.Body               `hcl:",body" json:"-"`
 }
 
 type OutputPlugin struct {
@@ -28,7 +47,68 @@ type OutputPlugin struct {
 	Plugin  string `hcl:"plugin"`
 	Threads int    `hcl:"threads,optional"`
 	Debug   bool   `hcl:"debug,optional"`
-	Options struct {
-		Data map[string]string `hcl:",remain"`
-	} `hcl:"options,block"`
+	// balance - compete with other outputs for messages, broadcast - receive copy of each message
+	Mode string `hcl:"mode,optional"`
+	// size of delivery queue, balance outputs share the queue of first of them
+	Queue int `hcl:"queue,optional"`
+	// wait for slow broadcast output instead of dropping messages, same as overflow = "block"
+	Block bool `hcl:"block,optional"`
+	// overflow policy of delivery queue, see queues.Overflow
+	Overflow       string `hcl:"overflow,optional"`
+	OverflowSample int    `hcl:"overflow_sample,optional"`
+	// expression, only matching messages are delivered to output
+	Route string `hcl:"route,optional"`
+	// only messages with any of only tags and without skip tags are delivered to output
+	OnlyTags []string               `hcl:"only_tags,optional"`
+	SkipTags []string               `hcl:"skip_tags,optional"`
+	Options  registry.Options       `hcl:"options,block"`
+	Factory  registry.OutputFactory `json:"-"`
+	Body     hcl.Body               `hcl:",body" json:"-"`
+}
+
+// create - build input plugin with own factory or registered one
+func (v InputPlugin) create(logger *log.Logger) (structs.Input, error) {
+	if v.Factory != nil {
+		return v.Factory(v.Options, logger)
+	}
+
+	return registry.NewInput(v.Plugin, v.Options, logger)
+}
+
+// queueOptions - options of queue written by filter
+func (v FilterPlugin) queueOptions() queues.Options {
+	return queues.Options{
+		Type:       v.QueueType,
+		Size:       v.Queue,
+		MaxBytes:   v.QueueMaxBytes,
+		Overflow:   v.Overflow,
+		SampleRate: v.OverflowSample,
+	}
+}
+
+// options - options of filter, empty when there is no options block
+func (v FilterPlugin) options() registry.Options {
+	if v.Options == nil {
+		return registry.Options{}
+	}
+
+	return *v.Options
+}
+
+// create - build filter plugin with own factory or registered one
+func (v FilterPlugin) create(logger *log.Logger) (structs.Filter, error) {
+	if v.Factory != nil {
+		return v.Factory(v.options(), logger)
+	}
+
+	return registry.NewFilter(v.Plugin, v.options(), logger)
+}
+
+// create - build output plugin with own factory or registered one
+func (v OutputPlugin) create(logger *log.Logger) (structs.Output, error) {
+	if v.Factory != nil {
+		return v.Factory(v.Options, logger)
+	}
+
+	return registry.NewOutput(v.Plugin, v.Options, logger)
 }
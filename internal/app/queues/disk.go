@@ -0,0 +1,789 @@
+package queues
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alxark/lonelog/internal/structs"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// size of in and out channels of disk queue
+	diskQueueBuffer = 1024
+
+	// maximum number of written messages waiting for flush
+	diskQueueFlushBatch = 1024
+
+	// how often segment file is synced and checkpoint is saved
+	diskQueueSyncInterval = time.Second
+
+	DefaultSegmentBytes = 64 << 20
+
+	segmentExtension = ".seg"
+	checkpointFile   = "checkpoint"
+
+	// record is prefixed with data length and crc32 checksum
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+)
+
+var errCorruptedRecord = errors.New("corrupted record")
+
+type segment struct {
+	start uint64
+	size  int64
+	path  string
+}
+
+/**
+ * DiskQueue keeps messages in append-only segment files. Every message gets
+ * sequence number, segment file is named by sequence number of its first
+ * message. Checkpoint file keeps sequence number of the first message which
+ * is not acknowledged yet, so after restart all unacknowledged messages are
+ * replayed. Segments are removed when all their messages are acknowledged.
+ *
+ * Message written to queue is acknowledged to previous queue only when it's
+ * flushed to segment file
+ */
+type DiskQueue struct {
+	log     *log.Logger
+	options Options
+
+	in  chan structs.Message
+	out chan structs.Message
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+
+	segments []*segment
+	file     *os.File
+	writer   *bufio.Writer
+	bytes    int64
+
+	// next message to write, first message which is not flushed yet,
+	// next message to read and first message which is not acknowledged
+	writeSeq   uint64
+	flushedSeq uint64
+	readSeq    uint64
+	ackSeq     uint64
+
+	// acknowledged messages after ackSeq, acknowledgements could come out of order
+	acked map[uint64]bool
+	dirty bool
+
+	closing bool
+	stopped bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	group    sync.WaitGroup
+}
+
+func NewDiskQueue(options Options, logger *log.Logger) (q *DiskQueue, err error) {
+	if options.Dir == "" {
+		return nil, errors.New("no directory for disk queue")
+	}
+
+	if options.Size <= 0 {
+		return nil, errors.New("disk queue size should be positive")
+	}
+
+	if options.SegmentBytes <= 0 {
+		options.SegmentBytes = DefaultSegmentBytes
+	}
+
+	// at least two segments are required, so old segment could be removed when queue is full
+	if options.MaxBytes > 0 && options.SegmentBytes > options.MaxBytes/2 {
+		options.SegmentBytes = options.MaxBytes / 2
+	}
+
+	q = &DiskQueue{}
+	q.log = logger
+	q.options = options
+	q.in = make(chan structs.Message, diskQueueBuffer)
+	q.out = make(chan structs.Message, diskQueueBuffer)
+	q.cond = sync.NewCond(&q.mutex)
+	q.acked = make(map[uint64]bool)
+	q.stop = make(chan struct{})
+
+	return q, nil
+}
+
+/**
+ * Load segments and checkpoint, truncate partially written record of the last
+ * segment and start processing from the first unacknowledged message
+ */
+func (q *DiskQueue) Open() (err error) {
+	if err = os.MkdirAll(q.options.Dir, 0755); err != nil {
+		return err
+	}
+
+	if err = q.loadSegments(); err != nil {
+		return err
+	}
+
+	q.ackSeq, err = q.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	if len(q.segments) == 0 {
+		q.writeSeq = q.ackSeq
+		if err = q.createSegment(); err != nil {
+			return err
+		}
+	} else {
+		last := q.segments[len(q.segments)-1]
+		count, size, err := scanSegment(last.path)
+		if err != nil {
+			return err
+		}
+
+		if size < last.size {
+			q.log.Printf("Truncating broken tail of %s: %d => %d bytes", last.path, last.size, size)
+			if err := os.Truncate(last.path, size); err != nil {
+				return err
+			}
+			q.bytes -= last.size - size
+			last.size = size
+		}
+
+		q.writeSeq = last.start + count
+		if err = q.openSegment(last); err != nil {
+			return err
+		}
+	}
+
+	if q.ackSeq < q.segments[0].start {
+		q.ackSeq = q.segments[0].start
+	}
+
+	if q.ackSeq > q.writeSeq {
+		q.ackSeq = q.writeSeq
+	}
+
+	q.flushedSeq = q.writeSeq
+	q.readSeq = q.ackSeq
+	q.cleanup()
+
+	q.log.Printf("Opened disk queue %s, segments: %d, messages to replay: %d", q.options.Dir, len(q.segments), q.writeSeq-q.ackSeq)
+
+	q.group.Add(3)
+	go q.pump()
+	go q.feed()
+	go q.maintain()
+
+	return nil
+}
+
+func (q *DiskQueue) In() chan structs.Message {
+	return q.in
+}
+
+func (q *DiskQueue) Out() chan structs.Message {
+	return q.out
+}
+
+func (q *DiskQueue) Len() int {
+	q.mutex.Lock()
+	size := int(q.writeSeq - q.readSeq)
+	q.mutex.Unlock()
+
+	return size + len(q.in) + len(q.out)
+}
+
+func (q *DiskQueue) Options() Options {
+	return q.options
+}
+
+/**
+ * Writers are finished. Messages from in channel are written to disk, reading
+ * is stopped, so messages which are not read yet are kept for next start
+ */
+func (q *DiskQueue) Close() {
+	close(q.in)
+}
+
+// Stop - stop processing, flush segment and save checkpoint
+func (q *DiskQueue) Stop() (err error) {
+	q.stopOnce.Do(func() {
+		q.mutex.Lock()
+		q.stopped = true
+		q.cond.Broadcast()
+		q.mutex.Unlock()
+
+		close(q.stop)
+		q.group.Wait()
+
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+
+		if q.file == nil {
+			return
+		}
+
+		if err = q.flush(); err != nil {
+			return
+		}
+
+		if err = q.file.Sync(); err != nil {
+			return
+		}
+
+		if err = q.file.Close(); err != nil {
+			return
+		}
+
+		err = q.saveCheckpoint()
+
+		q.log.Printf("Disk queue %s stopped, messages left: %d", q.options.Dir, q.writeSeq-q.ackSeq)
+	})
+
+	return err
+}
+
+/**
+ * Write messages from in channel to segment files. Writer is blocked while
+ * queue is full
+ */
+func (q *DiskQueue) pump() {
+	defer q.group.Done()
+
+	// messages written to queue, they are acknowledged after flush
+	var pending []structs.Message
+
+	for {
+		var msg structs.Message
+		var ok bool
+
+		select {
+		case msg, ok = <-q.in:
+		case <-q.stop:
+		}
+
+		if !ok {
+			break
+		}
+
+		q.mutex.Lock()
+		if q.isFull() {
+			// written messages should be available for reading, otherwise queue is never released
+			err := q.flush()
+			q.mutex.Unlock()
+
+			if err != nil {
+				q.log.Printf("failed to flush disk queue %s: %s", q.options.Dir, err.Error())
+			} else {
+				pending = acknowledge(pending)
+			}
+
+			q.mutex.Lock()
+			for q.isFull() && !q.stopped {
+				q.cond.Wait()
+			}
+		}
+
+		if q.stopped {
+			q.mutex.Unlock()
+			break
+		}
+
+		err := q.write(msg)
+		if err == nil && (len(q.in) == 0 || len(pending) >= diskQueueFlushBatch) {
+			err = q.flush()
+		}
+		flushed := q.flushedSeq == q.writeSeq
+		q.mutex.Unlock()
+
+		if err != nil {
+			q.log.Printf("failed to write disk queue %s: %s", q.options.Dir, err.Error())
+			continue
+		}
+
+		pending = append(pending, msg)
+		if flushed {
+			pending = acknowledge(pending)
+		}
+	}
+
+	q.mutex.Lock()
+	err := q.flush()
+	q.closing = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	if err != nil {
+		q.log.Printf("failed to flush disk queue %s: %s", q.options.Dir, err.Error())
+		return
+	}
+
+	acknowledge(pending)
+}
+
+/**
+ * Read flushed messages from segment files to out channel. Every message is
+ * acknowledged separately, so messages could be processed in parallel
+ */
+func (q *DiskQueue) feed() {
+	defer q.group.Done()
+	defer close(q.out)
+
+	var reader *segmentReader
+	defer func() {
+		if reader != nil {
+			reader.close()
+		}
+	}()
+
+	for {
+		q.mutex.Lock()
+		for q.readSeq >= q.flushedSeq && !q.closing && !q.stopped {
+			q.cond.Wait()
+		}
+
+		if q.closing || q.stopped {
+			q.mutex.Unlock()
+			return
+		}
+
+		seq := q.readSeq
+		current := q.segmentOf(seq)
+		q.mutex.Unlock()
+
+		if reader != nil && (reader.segment != current || reader.next != seq) {
+			reader.close()
+			reader = nil
+		}
+
+		var data []byte
+		var err error
+
+		if reader == nil {
+			reader, err = openSegmentReader(current, seq)
+		}
+
+		if err == nil {
+			data, err = reader.read()
+		}
+
+		if err != nil {
+			q.log.Printf("failed to read message %d from %s: %s, skipping rest of segment", seq, current.path, err.Error())
+			if reader != nil {
+				reader.close()
+				reader = nil
+			}
+
+			q.skipSegment(seq)
+			continue
+		}
+
+		var msg structs.Message
+		err = json.Unmarshal(data, &msg)
+
+		q.mutex.Lock()
+		q.readSeq = seq + 1
+		q.mutex.Unlock()
+
+		if err != nil {
+			q.log.Printf("failed to decode message %d from %s: %s", seq, current.path, err.Error())
+			q.ack(seq)
+			continue
+		}
+
+		msg.AckFunc = func() {
+			q.ack(seq)
+		}
+
+		select {
+		case q.out <- msg:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// maintain - periodically sync segment file and save checkpoint
+func (q *DiskQueue) maintain() {
+	defer q.group.Done()
+
+	ticker := time.NewTicker(diskQueueSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.stop:
+			return
+		}
+
+		q.mutex.Lock()
+		err := q.file.Sync()
+		if err == nil && q.dirty {
+			err = q.saveCheckpoint()
+		}
+		q.mutex.Unlock()
+
+		if err != nil {
+			q.log.Printf("failed to sync disk queue %s: %s", q.options.Dir, err.Error())
+		}
+	}
+}
+
+// ack - mark message as processed, move checkpoint when all previous messages are acknowledged
+func (q *DiskQueue) ack(seq uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if seq < q.ackSeq {
+		return
+	}
+
+	q.acked[seq] = true
+	if seq != q.ackSeq {
+		return
+	}
+
+	for q.acked[q.ackSeq] {
+		delete(q.acked, q.ackSeq)
+		q.ackSeq += 1
+	}
+	q.dirty = true
+
+	if len(q.segments) > 1 && q.segments[1].start <= q.ackSeq {
+		q.cleanup()
+	}
+
+	q.cond.Broadcast()
+}
+
+// skipSegment - drop unreadable messages from seq till the end of its segment
+func (q *DiskQueue) skipSegment(seq uint64) {
+	q.mutex.Lock()
+
+	end := q.writeSeq
+	for i, s := range q.segments {
+		if s.start <= seq && i < len(q.segments)-1 {
+			end = q.segments[i+1].start
+		}
+	}
+
+	// broken segment is written now, next messages are written to new one
+	if end == q.writeSeq {
+		if err := q.rotate(); err != nil {
+			q.log.Printf("failed to rotate disk queue %s: %s", q.options.Dir, err.Error())
+		}
+		end = q.writeSeq
+	}
+
+	q.log.Printf("Skipped %d messages of disk queue %s", end-seq, q.options.Dir)
+	q.readSeq = end
+	q.mutex.Unlock()
+
+	for i := seq; i < end; i += 1 {
+		q.ack(i)
+	}
+}
+
+func (q *DiskQueue) isFull() bool {
+	if q.writeSeq-q.ackSeq >= uint64(q.options.Size) {
+		return true
+	}
+
+	return q.options.MaxBytes > 0 && q.bytes >= q.options.MaxBytes
+}
+
+// write - append message to current segment, segment is rotated when it's filled
+func (q *DiskQueue) write(msg structs.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+
+	if _, err := q.writer.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := q.writer.Write(data); err != nil {
+		return err
+	}
+
+	size := int64(recordHeaderSize + len(data))
+	current := q.segments[len(q.segments)-1]
+	current.size += size
+	q.bytes += size
+	q.writeSeq += 1
+
+	if current.size >= q.options.SegmentBytes {
+		return q.rotate()
+	}
+
+	return nil
+}
+
+// flush - make written messages available for reading
+func (q *DiskQueue) flush() error {
+	if q.flushedSeq == q.writeSeq {
+		return nil
+	}
+
+	if err := q.writer.Flush(); err != nil {
+		return err
+	}
+
+	q.flushedSeq = q.writeSeq
+	q.cond.Broadcast()
+
+	return nil
+}
+
+func (q *DiskQueue) rotate() error {
+	if err := q.flush(); err != nil {
+		return err
+	}
+
+	if err := q.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := q.file.Close(); err != nil {
+		return err
+	}
+
+	return q.createSegment()
+}
+
+func (q *DiskQueue) createSegment() error {
+	s := &segment{
+		start: q.writeSeq,
+		path:  filepath.Join(q.options.Dir, fmt.Sprintf("%020d%s", q.writeSeq, segmentExtension)),
+	}
+
+	if err := q.openSegment(s); err != nil {
+		return err
+	}
+
+	q.segments = append(q.segments, s)
+
+	return nil
+}
+
+func (q *DiskQueue) openSegment(s *segment) (err error) {
+	q.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	q.writer = bufio.NewWriterSize(q.file, 64*1024)
+
+	return nil
+}
+
+// segmentOf - segment which contains message
+func (q *DiskQueue) segmentOf(seq uint64) *segment {
+	result := q.segments[0]
+	for _, s := range q.segments {
+		if s.start > seq {
+			break
+		}
+		result = s
+	}
+
+	return result
+}
+
+// cleanup - remove segments with acknowledged messages only, current segment is always kept
+func (q *DiskQueue) cleanup() {
+	for len(q.segments) > 1 && q.segments[1].start <= q.ackSeq {
+		s := q.segments[0]
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			q.log.Printf("failed to remove segment %s: %s", s.path, err.Error())
+			return
+		}
+
+		q.bytes -= s.size
+		q.segments = q.segments[1:]
+	}
+}
+
+func (q *DiskQueue) loadSegments() error {
+	entries, err := os.ReadDir(q.options.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		start, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		q.segments = append(q.segments, &segment{start: start, size: info.Size(), path: filepath.Join(q.options.Dir, name)})
+		q.bytes += info.Size()
+	}
+
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].start < q.segments[j].start
+	})
+
+	return nil
+}
+
+func (q *DiskQueue) loadCheckpoint() (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(q.options.Dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, errors.New("incorrect checkpoint in " + q.options.Dir + ": " + err.Error())
+	}
+
+	return seq, nil
+}
+
+// saveCheckpoint - replace checkpoint file atomically
+func (q *DiskQueue) saveCheckpoint() error {
+	path := filepath.Join(q.options.Dir, checkpointFile)
+
+	if err := os.WriteFile(path+".tmp", []byte(strconv.FormatUint(q.ackSeq, 10)), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	q.dirty = false
+
+	return nil
+}
+
+// acknowledge - acknowledge messages to previous queue
+func acknowledge(messages []structs.Message) []structs.Message {
+	for _, msg := range messages {
+		msg.Ack()
+	}
+
+	return messages[:0]
+}
+
+type segmentReader struct {
+	segment *segment
+	file    *os.File
+	reader  *bufio.Reader
+	next    uint64
+}
+
+// openSegmentReader - open segment and skip messages before seq
+func openSegmentReader(s *segment, seq uint64) (r *segmentReader, err error) {
+	r = &segmentReader{segment: s, next: s.start}
+
+	r.file, err = os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	r.reader = bufio.NewReaderSize(r.file, 64*1024)
+
+	for r.next < seq {
+		if _, err := r.read(); err != nil {
+			r.close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *segmentReader) read() ([]byte, error) {
+	data, err := readRecord(r.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	r.next += 1
+
+	return data, nil
+}
+
+func (r *segmentReader) close() {
+	r.file.Close()
+}
+
+// scanSegment - count valid records in segment and get their size
+func scanSegment(path string) (count uint64, size int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		data, err := readRecord(reader)
+		if err != nil {
+			break
+		}
+
+		count += 1
+		size += int64(recordHeaderSize + len(data))
+	}
+
+	return count, size, nil
+}
+
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > maxRecordSize {
+		return nil, errCorruptedRecord
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errCorruptedRecord
+	}
+
+	return data, nil
+}
@@ -0,0 +1,41 @@
+package queues
+
+import "github.com/alxark/lonelog/internal/structs"
+
+// MemoryQueue - plain channel, messages are lost on restart
+type MemoryQueue struct {
+	options Options
+	stream  chan structs.Message
+}
+
+func NewMemoryQueue(options Options) *MemoryQueue {
+	return &MemoryQueue{options: options, stream: make(chan structs.Message, options.Size)}
+}
+
+func (q *MemoryQueue) Open() error {
+	return nil
+}
+
+func (q *MemoryQueue) In() chan structs.Message {
+	return q.stream
+}
+
+func (q *MemoryQueue) Out() chan structs.Message {
+	return q.stream
+}
+
+func (q *MemoryQueue) Len() int {
+	return len(q.stream)
+}
+
+func (q *MemoryQueue) Close() {
+	close(q.stream)
+}
+
+func (q *MemoryQueue) Stop() error {
+	return nil
+}
+
+func (q *MemoryQueue) Options() Options {
+	return q.options
+}
@@ -0,0 +1,156 @@
+package queues
+
+import (
+	"errors"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// wait until queue has free space
+	OverflowBlock = "block"
+	// drop message which is written to full queue
+	OverflowDropNewest = "drop_newest"
+	// drop the oldest message from queue to free space for new one
+	OverflowDropOldest = "drop_oldest"
+	// keep every N-th message of full queue in place of the oldest one, drop others
+	OverflowSample = "sample"
+
+	DefaultSampleRate = 10
+)
+
+var droppedMetrics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ll",
+	Subsystem: "queue",
+	Name:      "dropped",
+	Help:      "Total number of messages dropped by queue overflow policy",
+}, []string{"pipeline", "stage", "policy"})
+
+var metricsRegisterOnce = sync.Once{}
+
+/**
+ * Overflow writes messages to queue according to policy. Dropped messages are
+ * acknowledged, so they are not replayed by persistent queues
+ */
+type Overflow struct {
+	Policy     string
+	SampleRate int
+
+	pipeline string
+	stage    string
+	sampled  uint64
+}
+
+// NewOverflow - create overflow policy, dropped messages are counted with pipeline and stage labels
+func NewOverflow(policy string, sampleRate int, pipeline string, stage string) (*Overflow, error) {
+	metricsRegisterOnce.Do(func() {
+		prometheus.MustRegister(droppedMetrics)
+	})
+
+	if policy == "" {
+		policy = OverflowBlock
+	}
+
+	switch policy {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowSample:
+	default:
+		return nil, errors.New("unknown overflow policy: " + policy + ", should be block, drop_newest, drop_oldest or sample")
+	}
+
+	if sampleRate <= 0 {
+		sampleRate = DefaultSampleRate
+	}
+
+	return &Overflow{Policy: policy, SampleRate: sampleRate, pipeline: pipeline, stage: stage}, nil
+}
+
+/**
+ * Push - write message to in channel. Out channel is used to drop the oldest
+ * messages, it's the same channel for memory queues
+ */
+func (o *Overflow) Push(in chan structs.Message, out chan structs.Message, msg structs.Message) {
+	if o.Policy == OverflowBlock {
+		in <- msg
+		return
+	}
+
+	select {
+	case in <- msg:
+		return
+	default:
+	}
+
+	switch o.Policy {
+	case OverflowDropNewest:
+		o.drop(msg)
+		return
+	case OverflowSample:
+		if atomic.AddUint64(&o.sampled, 1)%uint64(o.SampleRate) != 0 {
+			o.drop(msg)
+			return
+		}
+	}
+
+	for {
+		select {
+		case in <- msg:
+			return
+		case oldest, ok := <-out:
+			if ok {
+				o.drop(oldest)
+			}
+		}
+	}
+}
+
+func (o *Overflow) drop(msg structs.Message) {
+	droppedMetrics.WithLabelValues(o.pipeline, o.stage, o.Policy).Inc()
+	msg.Ack()
+}
+
+/**
+ * overflowQueue applies overflow policy to messages written to queue. Writers
+ * write to intake channel, pump moves messages to queue
+ */
+type overflowQueue struct {
+	Queue
+
+	overflow *Overflow
+	intake   chan structs.Message
+}
+
+func newOverflowQueue(queue Queue, overflow *Overflow) *overflowQueue {
+	return &overflowQueue{Queue: queue, overflow: overflow, intake: make(chan structs.Message)}
+}
+
+func (q *overflowQueue) Open() error {
+	if err := q.Queue.Open(); err != nil {
+		return err
+	}
+
+	go q.pump()
+
+	return nil
+}
+
+func (q *overflowQueue) pump() {
+	for msg := range q.intake {
+		q.overflow.Push(q.Queue.In(), q.Queue.Out(), msg)
+	}
+
+	q.Queue.Close()
+}
+
+func (q *overflowQueue) In() chan structs.Message {
+	return q.intake
+}
+
+func (q *overflowQueue) Len() int {
+	return q.Queue.Len() + len(q.intake)
+}
+
+func (q *overflowQueue) Close() {
+	close(q.intake)
+}
@@ -0,0 +1,105 @@
+package queues
+
+import (
+	"errors"
+	"github.com/alxark/lonelog/internal/structs"
+	"log"
+)
+
+const (
+	TypeMemory = "memory"
+	TypeDisk   = "disk"
+)
+
+/**
+ * Queue is a buffer between pipeline stages. Stage writes messages to In and
+ * next stage reads them from Out. Messages read from persistent queue should be
+ * acknowledged with Message.Ack when they are delivered or dropped, otherwise
+ * they are replayed after restart
+ */
+type Queue interface {
+	// Open - prepare queue for processing, called when pipeline is started
+	Open() error
+
+	// In - channel for messages written by stage
+	In() chan structs.Message
+
+	// Out - channel for messages read by next stage, closed when queue is closed
+	Out() chan structs.Message
+
+	// Len - number of messages waiting in queue
+	Len() int
+
+	// Close - all writers are finished, no more messages will be written
+	Close()
+
+	// Stop - release queue resources, unread messages of persistent queue are kept
+	Stop() error
+
+	// Options - queue settings, used to detect queue changes on reload
+	Options() Options
+}
+
+type Options struct {
+	// pipeline and queue names, used in metrics
+	Pipeline string
+	Name     string
+
+	Type string
+	// maximum number of messages in queue
+	Size int
+
+	// what to do when queue is full, see Overflow
+	Overflow   string
+	SampleRate int
+
+	// directory with segment files of disk queue
+	Dir string
+	// maximum size of segment files of disk queue, 0 - no limit
+	MaxBytes int64
+	// size of one segment file
+	SegmentBytes int64
+}
+
+// Validate - check queue options without creating queue
+func Validate(options Options) error {
+	if _, err := NewOverflow(options.Overflow, options.SampleRate, options.Pipeline, options.Name); err != nil {
+		return err
+	}
+
+	switch options.Type {
+	case "", TypeMemory, TypeDisk:
+		return nil
+	}
+
+	return errors.New("unknown queue type: " + options.Type + ", should be memory or disk")
+}
+
+// New - create queue, it should be opened before usage
+func New(options Options, logger *log.Logger) (queue Queue, err error) {
+	overflow, err := NewOverflow(options.Overflow, options.SampleRate, options.Pipeline, options.Name)
+	if err != nil {
+		return nil, err
+	}
+	options.Overflow = overflow.Policy
+	options.SampleRate = overflow.SampleRate
+
+	switch options.Type {
+	case "", TypeMemory:
+		options.Type = TypeMemory
+		queue = NewMemoryQueue(options)
+	case TypeDisk:
+		queue, err = NewDiskQueue(options, logger)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown queue type: " + options.Type + ", should be memory or disk")
+	}
+
+	if overflow.Policy == OverflowBlock {
+		return queue, nil
+	}
+
+	return newOverflowQueue(queue, overflow), nil
+}
@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/alxark/lonelog/internal/structs"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Options - options block of plugin. Plugins decode it to own options struct with
+ * hcl tags, so values are converted to field types and bad values are reported
+ * with their positions in configuration file
+ */
+type Options struct {
+	Body hcl.Body `hcl:",remain"`
+	// functions available in option expressions, like env()
+	Context *hcl.EvalContext
+}
+
+// MapOptions - options from key-value map, values are converted the same way as configuration strings
+func MapOptions(values map[string]string) Options {
+	if values == nil {
+		values = map[string]string{}
+	}
+
+	encoded, _ := json.Marshal(values)
+	file, _ := hcljson.Parse(encoded, "options")
+
+	return Options{Body: file.Body}
+}
+
+// Decode - decode options to struct with hcl tags, fields of absent options keep their values
+func (o Options) Decode(target interface{}) error {
+	body := o.Body
+	if body == nil {
+		body = hcl.EmptyBody()
+	}
+
+	diags := gohcl.DecodeBody(body, o.Context, target)
+	if !diags.HasErrors() {
+		return nil
+	}
+
+	// failed function calls are reported as unsuitable values too, only the first error of expression is kept
+	var result hcl.Diagnostics
+	reported := map[hcl.Range]bool{}
+	for _, diag := range diags {
+		if diag.Subject != nil {
+			if reported[*diag.Subject] {
+				continue
+			}
+			reported[*diag.Subject] = true
+		}
+
+		result = append(result, diag)
+	}
+
+	return result
+}
+
+/**
+ * Values - option values as strings, lists and objects are JSON encoded. Options
+ * of nested blocks are prefixed with block type and labels
+ */
+func (o Options) Values() map[string]string {
+	values := map[string]string{}
+	if o.Body != nil {
+		collectValues(o.Body, o.Context, "", values)
+	}
+
+	return values
+}
+
+// MarshalJSON - options are encoded as their values, so configurations could be compared
+func (o Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Values())
+}
+
+func collectValues(body hcl.Body, ctx *hcl.EvalContext, prefix string, values map[string]string) {
+	syntaxBody, ok := body.(*hclsyntax.Body)
+	if !ok {
+		attributes, _ := body.JustAttributes()
+		for name, attribute := range attributes {
+			value, _ := attribute.Expr.Value(ctx)
+			values[prefix+name] = formatValue(value)
+		}
+		return
+	}
+
+	for name, attribute := range syntaxBody.Attributes {
+		value, _ := attribute.Expr.Value(ctx)
+		values[prefix+name] = formatValue(value)
+	}
+
+	for i, block := range syntaxBody.Blocks {
+		blockPrefix := prefix + block.Type
+		for _, label := range block.Labels {
+			blockPrefix += "." + label
+		}
+
+		collectValues(block.Body, ctx, blockPrefix+"["+strconv.Itoa(i)+"].", values)
+	}
+}
+
+func formatValue(value cty.Value) string {
+	if !value.IsWhollyKnown() || value.IsNull() {
+		return ""
+	}
+
+	if value.Type() == cty.String {
+		return value.AsString()
+	}
+
+	encoded, err := ctyjson.Marshal(value, value.Type())
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
+}
+
+/**
+ * Factory constructors. Options are decoded to copy of defaults struct and
+ * passed to plugin constructor
+ */
+
+func NewInputFactory[T any, I structs.Input](defaults T, constructor func(options T, logger *log.Logger) (I, error)) InputFactory {
+	return func(options Options, logger *log.Logger) (structs.Input, error) {
+		decoded := defaults
+		if err := options.Decode(&decoded); err != nil {
+			return nil, err
+		}
+
+		return constructor(decoded, logger)
+	}
+}
+
+func NewFilterFactory[T any, F structs.Filter](defaults T, constructor func(options T, logger *log.Logger) (F, error)) FilterFactory {
+	return func(options Options, logger *log.Logger) (structs.Filter, error) {
+		decoded := defaults
+		if err := options.Decode(&decoded); err != nil {
+			return nil, err
+		}
+
+		return constructor(decoded, logger)
+	}
+}
+
+func NewOutputFactory[T any, O structs.Output](defaults T, constructor func(options T, logger *log.Logger) (O, error)) OutputFactory {
+	return func(options Options, logger *log.Logger) (structs.Output, error) {
+		decoded := defaults
+		if err := options.Decode(&decoded); err != nil {
+			return nil, err
+		}
+
+		return constructor(decoded, logger)
+	}
+}
+
+// StringList - list option, comma separated string is accepted as well
+func StringList(value cty.Value) (result []string, err error) {
+	if value.IsNull() {
+		return nil, nil
+	}
+
+	if value.Type() == cty.String {
+		for _, item := range strings.Split(value.AsString(), ",") {
+			result = append(result, strings.TrimSpace(item))
+		}
+		return result, nil
+	}
+
+	if !value.CanIterateElements() || value.Type().IsMapType() || value.Type().IsObjectType() {
+		return nil, errors.New("list of strings or comma separated string expected")
+	}
+
+	for it := value.ElementIterator(); it.Next(); {
+		_, item := it.Element()
+		if item.IsNull() || !item.Type().IsPrimitiveType() {
+			return nil, errors.New("list of strings expected")
+		}
+
+		result = append(result, formatValue(item))
+	}
+
+	return result, nil
+}
+
+// ParseDuration - duration option like 1m30s, numbers without unit are seconds
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New("incorrect duration: " + value)
+	}
+
+	return duration, nil
+}
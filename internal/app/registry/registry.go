@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"errors"
+	"github.com/alxark/lonelog/internal/structs"
+	"log"
+	"sort"
+	"sync"
+)
+
+const (
+	KindInput  = "input"
+	KindFilter = "filter"
+	KindOutput = "output"
+)
+
+type InputFactory func(options Options, logger *log.Logger) (structs.Input, error)
+type FilterFactory func(options Options, logger *log.Logger) (structs.Filter, error)
+type OutputFactory func(options Options, logger *log.Logger) (structs.Output, error)
+
+// Option - documented plugin option
+type Option struct {
+	Name        string
+	Description string
+	Default     string
+	Required    bool
+	// value is path to file which should exist
+	File bool
+}
+
+/**
+ * Schema describes plugin and options accepted by it. Plugins which accept
+ * arbitrary keys, like field names, describe them in Mapping
+ */
+type Schema struct {
+	Description string
+	Options     []Option
+	Mapping     string
+}
+
+// Plugin - registered plugin information
+type Plugin struct {
+	Kind string
+	Name string
+	Schema
+}
+
+// Option - find documented option by name
+func (p Plugin) Option(name string) (Option, bool) {
+	for _, o := range p.Options {
+		if o.Name == name {
+			return o, true
+		}
+	}
+
+	return Option{}, false
+}
+
+// Accepts - check if option key is known to plugin
+func (p Plugin) Accepts(name string) bool {
+	if p.Mapping != "" {
+		return true
+	}
+
+	_, ok := p.Option(name)
+	return ok
+}
+
+var (
+	mutex   = sync.RWMutex{}
+	plugins = map[string]map[string]Plugin{KindInput: {}, KindFilter: {}, KindOutput: {}}
+
+	inputs  = map[string]InputFactory{}
+	filters = map[string]FilterFactory{}
+	outputs = map[string]OutputFactory{}
+)
+
+// RegisterInput - make input plugin available in configuration, panics on duplicate names
+func RegisterInput(name string, factory InputFactory, schema Schema) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if factory == nil {
+		panic("registry: nil factory for input plugin " + name)
+	}
+
+	add(KindInput, name, schema)
+	inputs[name] = factory
+}
+
+// RegisterFilter - make filter plugin available in configuration, panics on duplicate names
+func RegisterFilter(name string, factory FilterFactory, schema Schema) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if factory == nil {
+		panic("registry: nil factory for filter plugin " + name)
+	}
+
+	add(KindFilter, name, schema)
+	filters[name] = factory
+}
+
+// RegisterOutput - make output plugin available in configuration, panics on duplicate names
+func RegisterOutput(name string, factory OutputFactory, schema Schema) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if factory == nil {
+		panic("registry: nil factory for output plugin " + name)
+	}
+
+	add(KindOutput, name, schema)
+	outputs[name] = factory
+}
+
+// add - save plugin information, registry should be locked
+func add(kind string, name string, schema Schema) {
+	if name == "" {
+		panic("registry: empty " + kind + " plugin name")
+	}
+
+	if _, ok := plugins[kind][name]; ok {
+		panic("registry: " + kind + " plugin " + name + " registered twice")
+	}
+
+	plugins[kind][name] = Plugin{Kind: kind, Name: name, Schema: schema}
+}
+
+// NewInput - create input plugin by name
+func NewInput(name string, options Options, logger *log.Logger) (structs.Input, error) {
+	mutex.RLock()
+	factory, ok := inputs[name]
+	mutex.RUnlock()
+
+	if !ok {
+		return nil, errors.New("unknown input plugin: " + name)
+	}
+
+	return factory(options, logger)
+}
+
+// NewFilter - create filter plugin by name
+func NewFilter(name string, options Options, logger *log.Logger) (structs.Filter, error) {
+	mutex.RLock()
+	factory, ok := filters[name]
+	mutex.RUnlock()
+
+	if !ok {
+		return nil, errors.New("unknown filter plugin: " + name)
+	}
+
+	return factory(options, logger)
+}
+
+// NewOutput - create output plugin by name
+func NewOutput(name string, options Options, logger *log.Logger) (structs.Output, error) {
+	mutex.RLock()
+	factory, ok := outputs[name]
+	mutex.RUnlock()
+
+	if !ok {
+		return nil, errors.New("unknown output plugin: " + name)
+	}
+
+	return factory(options, logger)
+}
+
+// Lookup - registered plugin information by kind and name
+func Lookup(kind string, name string) (Plugin, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	p, ok := plugins[kind][name]
+	return p, ok
+}
+
+// List - registered plugins of given kind sorted by name
+func List(kind string) []Plugin {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var result []Plugin
+	for _, p := range plugins[kind] {
+		result = append(result, p)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
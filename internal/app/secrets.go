@@ -0,0 +1,170 @@
+package app
+
+import (
+	"errors"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	secretPlaceholder = "[secret]"
+	// shorter secrets are rejected, their redaction would make logs unreadable
+	secretMinLength = 4
+)
+
+/**
+ * Secrets are values of configuration read with file() or marked by secret(), like
+ * secret(env("TOKEN")). They are replaced with placeholder in logs, status and
+ * configuration errors. Values of env() are not secrets, they are usually levels,
+ * hosts and ports, redacting them would mangle every text containing them
+ */
+var secrets = struct {
+	sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}{values: map[string]bool{}}
+
+// addSecret - remember value which should be redacted, short values can't be redacted
+func addSecret(value string) error {
+	value = strings.TrimSpace(value)
+	if len(value) < secretMinLength {
+		return errors.New("secret should be at least " + strconv.Itoa(secretMinLength) + " symbols long")
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	if secrets.values[value] {
+		return nil
+	}
+	secrets.values[value] = true
+
+	// longer values first, so secret containing other secret is replaced entirely
+	var values []string
+	for v := range secrets.values {
+		values = append(values, v)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	var pairs []string
+	for _, v := range values {
+		pairs = append(pairs, v, secretPlaceholder)
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+
+	return nil
+}
+
+// Redact - replace secrets of configuration in text
+func Redact(text string) string {
+	secrets.RLock()
+	replacer := secrets.replacer
+	secrets.RUnlock()
+
+	if replacer == nil {
+		return text
+	}
+
+	return replacer.Replace(text)
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+// RedactWriter - writer replacing secrets of configuration, used for logs
+func RedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w: w}
+}
+
+func (r redactWriter) Write(p []byte) (n int, err error) {
+	if _, err = io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+/**
+ * Evaluation context of configuration files:
+ *
+ *	env("NAME") or env("NAME", "default") - environment variable, it's not secret
+ *	file("/run/secrets/name") or file(path, "default") - file content without trailing spaces, it's secret
+ *	secret(value) - mark value as secret
+ */
+func configContext() *hcl.EvalContext {
+	return &hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"env":    envFunction,
+			"file":   fileFunction,
+			"secret": secretFunction,
+		},
+	}
+}
+
+var envFunction = function.New(&function.Spec{
+	Params:   []function.Parameter{{Name: "name", Type: cty.String}},
+	VarParam: &function.Parameter{Name: "default", Type: cty.String},
+	Type:     function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		name := args[0].AsString()
+		if value, ok := os.LookupEnv(name); ok {
+			return cty.StringVal(value), nil
+		}
+
+		return defaultArgument(args, "environment variable "+name+" is not set")
+	},
+})
+
+var fileFunction = function.New(&function.Spec{
+	Params:   []function.Parameter{{Name: "path", Type: cty.String}},
+	VarParam: &function.Parameter{Name: "default", Type: cty.String},
+	Type:     function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		content, err := os.ReadFile(args[0].AsString())
+		if err != nil {
+			return defaultArgument(args, err.Error())
+		}
+
+		value := strings.TrimRight(string(content), " \r\n\t")
+		if err := addSecret(value); err != nil {
+			return cty.NilVal, errors.New(args[0].AsString() + ": " + err.Error())
+		}
+
+		return cty.StringVal(value), nil
+	},
+})
+
+var secretFunction = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "value", Type: cty.String}},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		if err := addSecret(args[0].AsString()); err != nil {
+			return cty.NilVal, err
+		}
+
+		return args[0], nil
+	},
+})
+
+// defaultArgument - optional second argument of function, error is returned when it's not passed
+func defaultArgument(args []cty.Value, message string) (cty.Value, error) {
+	switch len(args) {
+	case 1:
+		return cty.NilVal, errors.New(message)
+	case 2:
+		return args[1], nil
+	default:
+		return cty.NilVal, function.NewArgErrorf(2, "only one default value is accepted")
+	}
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/alxark/lonelog/internal/structs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * Stage is a plugin running in one or more threads. Threads are tracked by two groups:
+ * stage group, used to stop exactly this stage on reload, and queue group, used by
+ * pipeline to close next queue when all its writers are finished
+ */
+type stage struct {
+	name      string
+	threads   int
+	signature string
+
+	input  structs.Input
+	filter structs.Filter
+	output structs.Output
+	// name of delivery which is read by output
+	delivery string
+
+	cancel context.CancelFunc
+	group  *sync.WaitGroup
+}
+
+// start - run thread body in all stage threads, splay is used as delay between threads launch
+func (s *stage) start(parent context.Context, queueGroup *sync.WaitGroup, splay time.Duration, run func(ctx context.Context, thread int)) {
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(parent)
+	s.group = &sync.WaitGroup{}
+
+	s.group.Add(s.threads)
+	queueGroup.Add(s.threads)
+
+	for thread := 0; thread < s.threads; thread += 1 {
+		go func(thread int) {
+			defer queueGroup.Done()
+			defer s.group.Done()
+
+			if splay > 0 && thread > 0 {
+				select {
+				case <-time.After(time.Duration(thread) * splay):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			run(ctx, thread)
+		}(thread)
+	}
+}
+
+/**
+ * Cancel stage threads and wait until current messages are processed. Threads
+ * which are not finished in timeout are left behind, so stuck plugin doesn't
+ * block reload
+ */
+func (s *stage) stop(timeout time.Duration) error {
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.group.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("threads of %s are not finished in %s", s.name, timeout)
+	}
+}
+
+/**
+ * Configuration fingerprint, used to detect changed stages on reload. Files referenced
+ * from options (rules, databases) are included too, so their updates are detected
+ */
+func stageSignature(config interface{}, options map[string]string) string {
+	hash := sha256.New()
+
+	encoded, _ := json.Marshal(config)
+	hash.Write(encoded)
+
+	var keys []string
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		info, err := os.Stat(options[key])
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		fmt.Fprintf(hash, "%s:%d:%d", options[key], info.Size(), info.ModTime().UnixNano())
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/**
+ * Signatures of running pipeline stages, they are taken by stages of reloaded
+ * configuration which are kept by Pipeline.Update. Inputs and outputs are matched
+ * by signature, filters by signature and position. Nil reuse takes nothing, reuse
+ * with all flag takes every stage, it's used to check queues layout only
+ */
+type stageReuse struct {
+	all bool
+
+	inputs     map[string]int
+	outputs    map[string]int
+	deadLetter map[string]int
+	filters    []string
+
+	// number of taken stages
+	taken int
+}
+
+func newStageReuse(running *Pipeline) *stageReuse {
+	running.mutex.Lock()
+	defer running.mutex.Unlock()
+
+	r := &stageReuse{inputs: stageSignatures(running.inputs), outputs: stageSignatures(running.outputs), deadLetter: stageSignatures(running.deadLetter)}
+	for _, s := range running.filters {
+		r.filters = append(r.filters, s.signature)
+	}
+
+	return r
+}
+
+func stageSignatures(stages []*stage) map[string]int {
+	signatures := make(map[string]int)
+	for _, s := range stages {
+		signatures[s.signature] += 1
+	}
+
+	return signatures
+}
+
+func (r *stageReuse) takeInput(signature string) bool {
+	return r != nil && r.take(r.inputs, signature)
+}
+
+func (r *stageReuse) takeOutput(signature string) bool {
+	return r != nil && r.take(r.outputs, signature)
+}
+
+func (r *stageReuse) takeDeadLetter(signature string) bool {
+	return r != nil && r.take(r.deadLetter, signature)
+}
+
+// take - check if running stage with signature is available and take it
+func (r *stageReuse) take(signatures map[string]int, signature string) bool {
+	if r.all {
+		r.taken += 1
+		return true
+	}
+
+	if signatures[signature] == 0 {
+		return false
+	}
+
+	signatures[signature] -= 1
+	r.taken += 1
+
+	return true
+}
+
+func (r *stageReuse) takeFilter(i int, signature string) bool {
+	if r == nil || (!r.all && (i >= len(r.filters) || r.filters[i] != signature)) {
+		return false
+	}
+
+	r.taken += 1
+
+	return true
+}
+
+// used - check if some stages were not created, because running ones are kept
+func (r *stageReuse) used() bool {
+	return r != nil && r.taken > 0
+}
@@ -1,13 +1,48 @@
 package app
 
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+var queueSizeMetrics = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ll",
+	Subsystem: "pipeline",
+	Name:      "queue_size",
+	Help:      "Number of messages waiting in pipeline queue",
+}, []string{"pipeline", "queue"})
+
+var statusRegisterOnce = sync.Once{}
+
 type PluginStatus struct {
-	Name string
-	Size int
+	Name      string
+	Size      int
 	Benchmark BenchmarkCounter
 }
 
 type PipelineStatus struct {
+	Name    string
 	In      PluginStatus
 	Filters []PluginStatus
 	Out     PluginStatus
+	// delivery queues, available when some outputs are in broadcast mode
+	Outputs    []PluginStatus
+	DeadLetter PluginStatus
+}
+
+// Export - publish queue sizes to prometheus
+func (s PipelineStatus) Export() {
+	statusRegisterOnce.Do(func() {
+		prometheus.MustRegister(queueSizeMetrics)
+	})
+
+	queueSizeMetrics.WithLabelValues(s.Name, s.In.Name).Set(float64(s.In.Size))
+	for _, filter := range s.Filters {
+		queueSizeMetrics.WithLabelValues(s.Name, filter.Name).Set(float64(filter.Size))
+	}
+	queueSizeMetrics.WithLabelValues(s.Name, s.Out.Name).Set(float64(s.Out.Size))
+	for _, output := range s.Outputs {
+		queueSizeMetrics.WithLabelValues(s.Name, output.Name).Set(float64(output.Size))
+	}
+	queueSizeMetrics.WithLabelValues(s.Name, s.DeadLetter.Name).Set(float64(s.DeadLetter.Size))
 }
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+/**
+ * Supervisor owns all pipelines described in configuration file and applies
+ * configuration changes on reload
+ */
+type Supervisor struct {
+	log        *log.Logger
+	ConfigPath string
+	Config     *Configuration
+	Pipelines  []*Pipeline
+
+	mutex sync.Mutex
+}
+
+func NewSupervisor(configPath string, logger *log.Logger) (s *Supervisor, err error) {
+	s = &Supervisor{}
+	s.log = logger
+	s.ConfigPath = configPath
+
+	s.Config, err = ReadConfig(configPath)
+	if err != nil {
+		return nil, errors.New("configuration parsing error. Got: " + err.Error())
+	}
+
+	s.Pipelines, err = s.buildPipelines(s.Config, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+/**
+ * Create pipelines of configuration. Unchanged stages of running pipelines are
+ * kept on reload, so they are not created again. Pipeline with changed queues
+ * layout is restarted, so all its stages are created. Already built pipelines
+ * are released when some pipeline could not be built
+ */
+func (s *Supervisor) buildPipelines(cfg *Configuration, running map[string]*Pipeline) (pipelines []*Pipeline, err error) {
+	for _, pipelineConfig := range cfg.Pipeline {
+		pipeline, err := s.buildPipeline(pipelineConfig, cfg.Global, running[pipelineConfig.Name])
+		if err != nil {
+			for _, built := range pipelines {
+				built.discard()
+			}
+
+			return nil, errors.New(fmt.Sprintf("failed to initialize pipeline %s: %s", pipelineConfig.Name, err.Error()))
+		}
+
+		pipelines = append(pipelines, pipeline)
+	}
+
+	return pipelines, nil
+}
+
+func (s *Supervisor) buildPipeline(cfg PipelineConfiguration, global GlobalConfiguration, running *Pipeline) (*Pipeline, error) {
+	// stopping pipeline can't be updated, so it's replaced with new one
+	if running == nil || running.isStopping() {
+		return NewPipeline(cfg, global, s.log)
+	}
+
+	// queues layout is checked without stages, so no plugin is created twice
+	layout, err := newPipeline(cfg, global, log.New(io.Discard, "", 0), &stageReuse{all: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if !running.IsCompatible(layout) {
+		return NewPipeline(cfg, global, s.log)
+	}
+
+	return newPipeline(cfg, global, s.log, newStageReuse(running))
+}
+
+// Start - run all pipelines
+func (s *Supervisor) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, pipeline := range s.Pipelines {
+		s.log.Printf("Starting pipeline %s", pipeline.Name)
+		go s.run(pipeline)
+	}
+}
+
+// run - process pipeline in background, pipeline which could not be started is only logged
+func (s *Supervisor) run(pipeline *Pipeline) {
+	if err := pipeline.Run(); err != nil {
+		s.log.Printf("Pipeline %s failed: %s", pipeline.Name, err.Error())
+	}
+}
+
+// GetPipelines - list of currently running pipelines
+func (s *Supervisor) GetPipelines() []*Pipeline {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*Pipeline{}, s.Pipelines...)
+}
+
+/**
+ * Re-read configuration file and apply changes. Changed stages are initialized
+ * first, so invalid configuration is rejected and current pipelines keep running.
+ * Unchanged stages are not touched, changed stages are replaced in place, pipelines
+ * with changed queues layout are drained and restarted. Replacement of pipeline is
+ * built before it's stopped, pipeline which could not be replaced keeps running
+ * and error is returned
+ */
+func (s *Supervisor) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.log.Printf("Reloading configuration from %s", s.ConfigPath)
+
+	cfg, err := ReadConfig(s.ConfigPath)
+	if err != nil {
+		return errors.New("configuration parsing error. Got: " + err.Error())
+	}
+
+	current := make(map[string]*Pipeline)
+	for _, pipeline := range s.Pipelines {
+		current[pipeline.Name] = pipeline
+	}
+
+	next, err := s.buildPipelines(cfg, current)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Global.HttpPort != s.Config.Global.HttpPort {
+		s.log.Printf("HTTP port change requires restart, still using %d", s.Config.Global.HttpPort)
+	}
+
+	var pipelines []*Pipeline
+	var failed []string
+	for i, pipeline := range next {
+		running, ok := current[pipeline.Name]
+		if !ok {
+			s.log.Printf("Starting new pipeline %s", pipeline.Name)
+			go s.run(pipeline)
+			pipelines = append(pipelines, pipeline)
+			continue
+		}
+		delete(current, pipeline.Name)
+
+		err := running.Update(pipeline)
+		if err == nil {
+			pipelines = append(pipelines, running)
+			continue
+		}
+
+		s.log.Printf("Pipeline %s could not be updated in place (%s), restarting", pipeline.Name, err.Error())
+
+		// stages of running pipeline were reused, so all of them are created now
+		if pipeline.reuse.used() {
+			pipeline.discard()
+
+			if pipeline, err = NewPipeline(cfg.Pipeline[i], cfg.Global, s.log); err != nil {
+				s.log.Printf("Failed to restart pipeline %s: %s", running.Name, err.Error())
+				failed = append(failed, running.Name+": "+err.Error())
+				pipelines = append(pipelines, running)
+				continue
+			}
+		}
+
+		if err := running.Stop(); err != nil {
+			s.log.Printf("Pipeline %s stopped with error: %s", running.Name, err.Error())
+		}
+
+		go s.run(pipeline)
+		pipelines = append(pipelines, pipeline)
+	}
+
+	for name, pipeline := range current {
+		s.log.Printf("Removing pipeline %s", name)
+		if err := pipeline.Stop(); err != nil {
+			s.log.Printf("Pipeline %s stopped with error: %s", name, err.Error())
+		}
+	}
+
+	s.Config = cfg
+	s.Pipelines = pipelines
+	s.log.Printf("Configuration reloaded, total pipelines: %d", len(s.Pipelines))
+
+	if len(failed) > 0 {
+		return errors.New("failed to restart pipelines: " + strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// Stop - drain all pipelines, they are independent so they are stopped in parallel
+func (s *Supervisor) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, pipeline := range s.Pipelines {
+		wg.Add(1)
+		go func(pipeline *Pipeline) {
+			defer wg.Done()
+
+			if err := pipeline.Stop(); err != nil {
+				s.log.Printf("Pipeline %s stopped with error: %s", pipeline.Name, err.Error())
+			}
+		}(pipeline)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,59 @@
+package app
+
+import "github.com/alxark/lonelog/internal/structs"
+
+/**
+ * Tag selector of filter or output set by only_tags and skip_tags. Message is
+ * selected when it has any of only tags and none of skip tags, empty only list
+ * selects all messages
+ */
+type tagSelector struct {
+	only []string
+	skip []string
+}
+
+func newTagSelector(only []string, skip []string) tagSelector {
+	return tagSelector{only: only, skip: skip}
+}
+
+func (s tagSelector) empty() bool {
+	return len(s.only) == 0 && len(s.skip) == 0
+}
+
+func (s tagSelector) equal(other tagSelector) bool {
+	return equalStrings(s.only, other.only) && equalStrings(s.skip, other.skip)
+}
+
+func (s tagSelector) Match(msg structs.Message) bool {
+	if len(s.only) > 0 && !hasAnyTag(msg.Tags, s.only) {
+		return false
+	}
+
+	return !hasAnyTag(msg.Tags, s.skip)
+}
+
+func hasAnyTag(tags []string, selected []string) bool {
+	for _, tag := range tags {
+		for _, v := range selected {
+			if tag == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,42 @@
+package structs
+
+import "time"
+
+const (
+	DeadLetterTag        = "dead_letter"
+	DeadLetterStageField = "dlq_stage"
+	DeadLetterErrorField = "dlq_error"
+	DeadLetterTimeField  = "dlq_time"
+)
+
+/**
+ * DeadLetter - message which could not be processed by filter or delivered by
+ * output, with information about the failure
+ */
+type DeadLetter struct {
+	Message Message
+	Stage   string
+	Error   string
+	Time    time.Time
+}
+
+/**
+ * ToMessage - original message with failure details in payload, tagged as
+ * dead letter. Original payload is kept, so message could be replayed later
+ */
+func (d DeadLetter) ToMessage() Message {
+	msg := d.Message
+
+	payload := make(map[string]string, len(msg.Payload)+3)
+	for key, value := range msg.Payload {
+		payload[key] = value
+	}
+	payload[DeadLetterStageField] = d.Stage
+	payload[DeadLetterErrorField] = d.Error
+	payload[DeadLetterTimeField] = d.Time.Format(time.RFC3339)
+	msg.Payload = payload
+
+	msg.Tags = append(append([]string{}, msg.Tags...), DeadLetterTag)
+
+	return msg
+}
@@ -1,13 +1,47 @@
 package structs
 
-import "context"
+import (
+	"context"
+	"log"
+)
 
 type Filter interface {
 	Proceed(ctx context.Context, input chan Message, output chan Message) error
 	SetField(fieldName string) error
 	SetName(filterName string) error
 	GetName() string
+	SetPipeline(pipelineName string)
 	SetServiceInterval(int)
 	SetDebug(bool)
+	// SetDeadLetter - set queue for messages which could not be processed
+	SetDeadLetter(chan DeadLetter)
+	// SetLogger - set pipeline logger
+	SetLogger(*log.Logger)
 	Init() error
 }
+
+type selectorKey struct{}
+
+type selector struct {
+	match  func(Message) bool
+	output chan Message
+}
+
+/**
+ * WithSelector - context of filter which processes only matched messages, others
+ * are written to output as is when filter reads them. Filter reads next message
+ * only when previous one is written, so skipped messages keep their order
+ */
+func WithSelector(ctx context.Context, match func(Message) bool, output chan Message) context.Context {
+	return context.WithValue(ctx, selectorKey{}, selector{match: match, output: output})
+}
+
+// Skipped - output for message which is not selected for filter, ok is false when filter should process message
+func Skipped(ctx context.Context, msg Message) (output chan Message, ok bool) {
+	s, found := ctx.Value(selectorKey{}).(selector)
+	if !found || s.match(msg) {
+		return nil, false
+	}
+
+	return s.output, true
+}
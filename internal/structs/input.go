@@ -1,9 +1,13 @@
 package structs
 
+import "context"
+
 type Input interface {
-	AcceptTo(chan Message, chan int) error
+	// AcceptTo - read messages from source until context is cancelled
+	AcceptTo(context.Context, chan Message, chan int) error
 	IsMultiThread() bool
 	Init() error
 	SetName(string) error
 	GetName() string
+	SetPipeline(string)
 }
@@ -1,6 +1,9 @@
 package structs
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type Message struct {
 	AcceptTime time.Time
@@ -8,4 +11,25 @@ type Message struct {
 	Tags       []string
 	Content    string
 	Payload    map[string]string
+
+	// AckFunc - called when message is processed, set by persistent queues
+	AckFunc func() `json:"-"`
+}
+
+// Ack - acknowledge that message is delivered or dropped and should not be replayed
+func (m Message) Ack() {
+	if m.AckFunc != nil {
+		m.AckFunc()
+	}
+}
+
+// ShareAck - acknowledge message only when all messages created from it are acknowledged
+func ShareAck(ack func(), copies int) func() {
+	left := int32(copies)
+
+	return func() {
+		if atomic.AddInt32(&left, -1) == 0 {
+			ack()
+		}
+	}
 }
@@ -1,7 +1,13 @@
 package structs
 
+import (
+	"context"
+	"log"
+)
+
 type Output interface {
-	ReadFrom(chan Message, map[string]string, chan int) error
+	// ReadFrom - write messages from channel until it's closed or context is cancelled
+	ReadFrom(context.Context, chan Message, map[string]string, chan int) error
 
 	// SetDebug - enable debugging
 	SetDebug(bool)
@@ -14,4 +20,13 @@ type Output interface {
 
 	// GetName - get output name
 	GetName() string
+
+	// SetPipeline - set name of pipeline which owns this output
+	SetPipeline(string)
+
+	// SetDeadLetter - set queue for messages which could not be delivered
+	SetDeadLetter(chan DeadLetter)
+
+	// SetLogger - set pipeline logger
+	SetLogger(*log.Logger)
 }
@@ -0,0 +1,54 @@
+/**
+ * Package lonelog is a public API of lonelog engine. It allows to write plugins
+ * outside of this module and to run pipelines inside of other applications.
+ *
+ * Plugins are registered from init() of their packages and become available in
+ * configuration files of binaries which import them:
+ *
+ *	func init() {
+ *		factory := lonelog.NewFilterFactory(UpperOptions{Limit: 100}, NewUpperFilter)
+ *		lonelog.RegisterFilter("upper", factory, lonelog.Schema{Description: "Uppercase fields"})
+ *	}
+ *
+ * Plugin options are decoded to options struct of plugin, see Options.
+ *
+ * Pipelines are assembled with Builder, see NewBuilder.
+ */
+package lonelog
+
+import (
+	"github.com/alxark/lonelog/internal/app/filters"
+	"github.com/alxark/lonelog/internal/app/inputs"
+	"github.com/alxark/lonelog/internal/app/outputs"
+	"github.com/alxark/lonelog/internal/structs"
+)
+
+// Message - log record passed through pipeline
+type Message = structs.Message
+
+// DeadLetter - message rejected by filter or output with rejection reason
+type DeadLetter = structs.DeadLetter
+
+const (
+	DeadLetterTag        = structs.DeadLetterTag
+	DeadLetterStageField = structs.DeadLetterStageField
+	DeadLetterErrorField = structs.DeadLetterErrorField
+	DeadLetterTimeField  = structs.DeadLetterTimeField
+)
+
+// Input - source of messages, AcceptTo writes messages until context is cancelled
+type Input = structs.Input
+
+// Filter - pipeline stage, Proceed reads input and writes processed messages to output
+type Filter = structs.Filter
+
+// Output - messages destination, ReadFrom writes messages until input is closed
+type Output = structs.Output
+
+/**
+ * Base helpers for plugins. They implement naming, metrics and dead letter
+ * handling, plugins embed them and implement only processing methods
+ */
+type BasicInput = inputs.BasicInput
+type BasicFilter = filters.BasicFilter
+type BasicOutput = outputs.BasicOutput
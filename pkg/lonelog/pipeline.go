@@ -0,0 +1,151 @@
+package lonelog
+
+import (
+	"github.com/alxark/lonelog/internal/app"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"log"
+)
+
+// stage configurations, the same as blocks of configuration file
+type GlobalConfig = app.GlobalConfiguration
+type InputConfig = app.InputPlugin
+type FilterConfig = app.FilterPlugin
+type OutputConfig = app.OutputPlugin
+
+type PipelineStatus = app.PipelineStatus
+
+/**
+ * Builder assembles pipeline from registered plugins and plugins created by
+ * application. Plugin instances passed to builder should be used by one pipeline only
+ *
+ *	pipeline, err := lonelog.NewBuilder("events").
+ *		InputPlugin("app", input).
+ *		FilterFunc("enrich", func(msg *lonelog.Message) bool { ... }).
+ *		Output("store", "clickhouse", options).
+ *		Build(logger)
+ */
+type Builder struct {
+	config app.PipelineConfiguration
+	global app.GlobalConfiguration
+}
+
+func NewBuilder(name string) *Builder {
+	b := &Builder{}
+	b.config.Name = name
+
+	return b
+}
+
+// Global - set queues, statistics and shutdown settings
+func (b *Builder) Global(global GlobalConfig) *Builder {
+	b.global = global
+	return b
+}
+
+// AddInput - add input with full stage configuration
+func (b *Builder) AddInput(config InputConfig) *Builder {
+	b.config.In.Input = append(b.config.In.Input, config)
+	return b
+}
+
+// Input - add registered input plugin
+func (b *Builder) Input(name string, plugin string, options map[string]string) *Builder {
+	config := InputConfig{Name: name, Plugin: plugin}
+	config.Options = registry.MapOptions(options)
+
+	return b.AddInput(config)
+}
+
+// InputPlugin - add input created by application
+func (b *Builder) InputPlugin(name string, input Input) *Builder {
+	return b.AddInput(InputConfig{Name: name, Plugin: name, Factory: func(Options, *log.Logger) (Input, error) {
+		return input, nil
+	}})
+}
+
+// AddFilter - add filter with full stage configuration
+func (b *Builder) AddFilter(config FilterConfig) *Builder {
+	b.config.Filter = append(b.config.Filter, config)
+	return b
+}
+
+// Filter - add registered filter plugin
+func (b *Builder) Filter(name string, plugin string, options map[string]string) *Builder {
+	filterOptions := registry.MapOptions(options)
+	config := FilterConfig{Name: name, Plugin: plugin, Options: &filterOptions}
+
+	return b.AddFilter(config)
+}
+
+// FilterPlugin - add filter created by application
+func (b *Builder) FilterPlugin(name string, filter Filter) *Builder {
+	return b.AddFilter(FilterConfig{Name: name, Plugin: name, Factory: func(Options, *log.Logger) (Filter, error) {
+		return filter, nil
+	}})
+}
+
+// FilterFunc - add filter calling fn for every message
+func (b *Builder) FilterFunc(name string, fn FilterFunc) *Builder {
+	return b.FilterPlugin(name, NewFuncFilter(fn))
+}
+
+// AddOutput - add output with full stage configuration, including delivery mode and route
+func (b *Builder) AddOutput(config OutputConfig) *Builder {
+	b.config.Out.Output = append(b.config.Out.Output, config)
+	return b
+}
+
+// Output - add registered output plugin
+func (b *Builder) Output(name string, plugin string, options map[string]string) *Builder {
+	config := OutputConfig{Name: name, Plugin: plugin}
+	config.Options = registry.MapOptions(options)
+
+	return b.AddOutput(config)
+}
+
+// OutputPlugin - add output created by application
+func (b *Builder) OutputPlugin(name string, output Output) *Builder {
+	return b.AddOutput(OutputConfig{Name: name, Plugin: name, Factory: func(Options, *log.Logger) (Output, error) {
+		return output, nil
+	}})
+}
+
+// DeadLetter - set output for messages rejected by filters and outputs
+func (b *Builder) DeadLetter(config OutputConfig) *Builder {
+	b.config.DeadLetter = &config
+	return b
+}
+
+// Build - create pipeline, plugins are initialized but not started
+func (b *Builder) Build(logger *log.Logger) (*Pipeline, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	pipeline, err := app.NewPipeline(b.config, b.global, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pipeline{pipeline: pipeline}, nil
+}
+
+// Pipeline - running instance of pipeline
+type Pipeline struct {
+	pipeline *app.Pipeline
+}
+
+// Run - process messages until pipeline is stopped or all inputs are finished, it blocks
+func (p *Pipeline) Run() error {
+	return p.pipeline.Run()
+}
+
+// Stop - stop inputs and wait until queued messages are delivered, should be called after Run
+func (p *Pipeline) Stop() error {
+	return p.pipeline.Stop()
+}
+
+// Status - queue sizes and benchmarks of pipeline stages
+func (p *Pipeline) Status() PipelineStatus {
+	return p.pipeline.GetStatus()
+}
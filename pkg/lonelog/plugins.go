@@ -0,0 +1,133 @@
+package lonelog
+
+import (
+	"context"
+	"github.com/alxark/lonelog/internal/app/registry"
+	"log"
+	"time"
+)
+
+type InputFactory = registry.InputFactory
+type FilterFactory = registry.FilterFactory
+type OutputFactory = registry.OutputFactory
+
+// Option - documented plugin option, shown by `lonelog plugins`
+type Option = registry.Option
+
+// Schema - plugin description and its options
+type Schema = registry.Schema
+
+/**
+ * Options - options block of plugin. Factories built by NewInputFactory,
+ * NewFilterFactory and NewOutputFactory decode it to options struct with hcl tags:
+ *
+ *	type UpperOptions struct {
+ *		Fields []string `hcl:"fields"`
+ *		Limit  int      `hcl:"limit,optional"`
+ *	}
+ */
+type Options = registry.Options
+
+// MapOptions - options from key-value map, values are converted to option types on decoding
+func MapOptions(values map[string]string) Options {
+	return registry.MapOptions(values)
+}
+
+// NewInputFactory - factory decoding options to copy of defaults and passing them to constructor
+func NewInputFactory[T any, I Input](defaults T, constructor func(options T, logger *log.Logger) (I, error)) InputFactory {
+	return registry.NewInputFactory(defaults, constructor)
+}
+
+// NewFilterFactory - factory decoding options to copy of defaults and passing them to constructor
+func NewFilterFactory[T any, F Filter](defaults T, constructor func(options T, logger *log.Logger) (F, error)) FilterFactory {
+	return registry.NewFilterFactory(defaults, constructor)
+}
+
+// NewOutputFactory - factory decoding options to copy of defaults and passing them to constructor
+func NewOutputFactory[T any, O Output](defaults T, constructor func(options T, logger *log.Logger) (O, error)) OutputFactory {
+	return registry.NewOutputFactory(defaults, constructor)
+}
+
+// RegisterInput - make input plugin available in configuration, panics on duplicate names
+func RegisterInput(name string, factory InputFactory, schema Schema) {
+	registry.RegisterInput(name, factory, schema)
+}
+
+// RegisterFilter - make filter plugin available in configuration, panics on duplicate names
+func RegisterFilter(name string, factory FilterFactory, schema Schema) {
+	registry.RegisterFilter(name, factory, schema)
+}
+
+// RegisterOutput - make output plugin available in configuration, panics on duplicate names
+func RegisterOutput(name string, factory OutputFactory, schema Schema) {
+	registry.RegisterOutput(name, factory, schema)
+}
+
+// FilterFunc - update message in place, message is dropped when false is returned
+type FilterFunc func(msg *Message) bool
+
+// FuncFilter - filter calling FilterFunc for every message, it's safe for multiple threads
+type FuncFilter struct {
+	BasicFilter
+
+	fn FilterFunc
+}
+
+func NewFuncFilter(fn FilterFunc) *FuncFilter {
+	return &FuncFilter{fn: fn}
+}
+
+func (f *FuncFilter) Proceed(ctx context.Context, input chan Message, output chan Message) (err error) {
+	for ctx.Err() == nil {
+		msg, ok := f.ReadMessage(ctx, input)
+		if !ok {
+			break
+		}
+
+		if !f.fn(&msg) {
+			f.Drop(msg)
+			continue
+		}
+
+		_ = f.WriteMessage(output, msg)
+	}
+
+	return
+}
+
+/**
+ * ChannelInput passes messages sent by application to pipeline. Input is
+ * finished when Messages channel is closed or pipeline is stopped
+ */
+type ChannelInput struct {
+	BasicInput
+
+	Messages chan Message
+}
+
+func NewChannelInput(size int) *ChannelInput {
+	return &ChannelInput{Messages: make(chan Message, size)}
+}
+
+func (i *ChannelInput) AcceptTo(ctx context.Context, output chan Message, counter chan int) (err error) {
+	for {
+		select {
+		case msg, ok := <-i.Messages:
+			if !ok {
+				return
+			}
+
+			if msg.Payload == nil {
+				msg.Payload = map[string]string{}
+			}
+
+			if msg.AcceptTime.IsZero() {
+				msg.AcceptTime = time.Now()
+			}
+
+			_ = i.WriteMessage(output, msg)
+		case <-ctx.Done():
+			return
+		}
+	}
+}